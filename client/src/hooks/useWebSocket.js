@@ -6,13 +6,14 @@ const useWebSocket = (onMessage) => {
   const reconnectAttempts = useRef(0);
   const maxReconnectAttempts = 5;
   const reconnectDelay = useRef(1000);
+  const lastSeq = useRef(null); // newest event seen, so a reconnect replays only what was missed
 
-  const connect = useCallback(() => {
+  const connect = useCallback(async () => {
     const token = localStorage.getItem('token');
     if (!token) return;
 
     try {
-      const wsUrl = getWebSocketURL();
+      const wsUrl = await getWebSocketURL(lastSeq.current);
       ws.current = new WebSocket(wsUrl);
 
       ws.current.onopen = () => {
@@ -24,6 +25,11 @@ const useWebSocket = (onMessage) => {
       ws.current.onmessage = (event) => {
         try {
           const data = JSON.parse(event.data);
+          if (data.type === 'connected' && lastSeq.current == null) {
+            lastSeq.current = data.payload.seq;
+          } else if (data.seq) {
+            lastSeq.current = Math.max(lastSeq.current || 0, data.seq);
+          }
           if (onMessage) {
             onMessage(data);
           }
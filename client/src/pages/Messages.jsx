@@ -46,13 +46,54 @@ const Messages = () => {
   // WebSocket connection for real-time messages
   const handleWebSocketMessage = (data) => {
     console.log('WebSocket message received:', data);
-    if (data.type === 'new_message' && data.message) {
-      const message = data.message;
+    // Some missed events are gone from the server, so reload what is on screen
+    if (data.type === 'resync' && selectedFriend) {
+      fetchConversation(selectedFriend.ID || selectedFriend.id);
+      return;
+    }
+    if (data.type === 'message.edited' && data.payload) {
+      const { message_id, content, edited_at } = data.payload;
+      setMessages(prev => prev.map(m => m.ID === message_id ? { ...m, content, edited_at } : m));
+      return;
+    }
+    if (data.type === 'message.reaction' && data.payload) {
+      const { message_id, user_id, emoji, action } = data.payload;
+      setMessages(prev => prev.map(m => {
+        if (m.ID !== message_id) return m;
+        const reactions = (m.reactions || []).map(r => ({ ...r, user_ids: [...r.user_ids] }));
+        let reaction = reactions.find(r => r.emoji === emoji);
+        if (action === 'added') {
+          if (!reaction) {
+            reaction = { emoji, count: 0, user_ids: [] };
+            reactions.push(reaction);
+          }
+          if (!reaction.user_ids.includes(user_id)) {
+            reaction.user_ids.push(user_id);
+            reaction.count++;
+          }
+        } else if (reaction) {
+          reaction.user_ids = reaction.user_ids.filter(id => id !== user_id);
+          reaction.count = reaction.user_ids.length;
+        }
+        return { ...m, reactions: reactions.filter(r => r.count > 0) };
+      }));
+      return;
+    }
+    if (data.type === 'message.deleted' && data.payload) {
+      const { message_id, for_everyone, deleted_at } = data.payload;
+      setMessages(prev => for_everyone
+        ? prev.map(m => m.ID === message_id ? { ...m, content: '', unsent_at: deleted_at } : m)
+        : prev.filter(m => m.ID !== message_id));
+      return;
+    }
+    if (data.type === 'message.new' && data.payload) {
+      const message = data.payload;
       // Only add message if it's part of the current conversation
       if (selectedFriend && 
           ((message.sender_id == currentUser.id && message.receiver_id == (selectedFriend.ID || selectedFriend.id)) ||
            (message.sender_id == (selectedFriend.ID || selectedFriend.id) && message.receiver_id == currentUser.id))) {
-        setMessages(prev => [...prev, message]);
+        // Messages this tab sent over REST come back here too
+        setMessages(prev => prev.some(m => m.ID === message.ID) ? prev : [...prev, message]);
       }
     }
   };
@@ -129,9 +170,9 @@ const Messages = () => {
     try {
       setSendingMessage(true);
       const friendId = selectedFriend.ID || selectedFriend.id;
-      const response = await sendMessage(friendId, newMessage.trim());
+      const response = await sendMessage(friendId, newMessage.trim(), crypto.randomUUID());
       if (response.message) {
-        setMessages([...messages, response.message]);
+        setMessages(prev => prev.some(m => m.ID === response.message.ID) ? prev : [...prev, response.message]);
         setNewMessage('');
       } else {
         setError(response.error || 'Failed to send message');
@@ -306,14 +347,35 @@ const Messages = () => {
                                   : 'bg-gray-100 text-gray-900'
                               }`}
                             >
-                              <p className="text-sm">{message.content}</p>
+                              {message.reply_to && (
+                                <p className="text-xs mb-1 pl-2 border-l-2 opacity-75 truncate">
+                                  {message.reply_to.unsent
+                                    ? 'Deleted message'
+                                    : message.reply_to.before_joined
+                                      ? 'Message from before you joined'
+                                      : message.reply_to.content}
+                                </p>
+                              )}
+                              <p className={`text-sm ${message.unsent_at ? 'italic opacity-75' : ''}`}>
+                                {message.unsent_at ? 'This message was deleted' : message.content}
+                              </p>
                               <p
                                 className={`text-xs mt-1 ${
                                   isOwnMessage ? 'text-green-100' : 'text-gray-500'
                                 }`}
                               >
                                 {formatMessageTime(message.CreatedAt)}
+                                {message.edited_at && !message.unsent_at && ' · edited'}
                               </p>
+                              {message.reactions && message.reactions.length > 0 && (
+                                <div className="flex gap-1 mt-1">
+                                  {message.reactions.map((r) => (
+                                    <span key={r.emoji} className="text-xs bg-white/20 rounded-full px-1.5">
+                                      {r.emoji} {r.count}
+                                    </span>
+                                  ))}
+                                </div>
+                              )}
                             </div>
                           </div>
                         );
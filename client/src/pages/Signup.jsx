@@ -1,6 +1,6 @@
 import React, { useState } from 'react';
 import { Link, useNavigate } from 'react-router-dom';
-import { register } from '../utils/api';
+import { register, saveSession } from '../utils/api';
 
 const Signup = () => {
   const [formData, setFormData] = useState({
@@ -66,7 +66,7 @@ const Signup = () => {
       const result = await register(formData.username, formData.email, formData.password);
       
       if (result.token) {
-        localStorage.setItem('token', result.token);
+        saveSession(result);
         navigate('/home');
       } else {
         setError(result.error || 'Registration failed');
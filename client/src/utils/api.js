@@ -5,10 +5,70 @@ const getAuthToken = () => {
   return localStorage.getItem('token');
 };
 
-// Helper function to make authenticated requests
-const authenticatedRequest = async (url, options = {}) => {
+// Keeps the tokens from a login or refresh. The device ID stays the same across
+// logins so the server sees one session per browser.
+export const saveSession = (result) => {
+  localStorage.setItem('token', result.token);
+  if (result.refresh_token) localStorage.setItem('refresh_token', result.refresh_token);
+  if (result.device_id) localStorage.setItem('device_id', result.device_id);
+};
+
+// Forgets the tokens; the device ID is kept for the next login
+export const clearSession = () => {
+  localStorage.removeItem('token');
+  localStorage.removeItem('refresh_token');
+};
+
+// Sends the user back to the login page once their session can't be refreshed
+const endSession = () => {
+  clearSession();
+  if (window.location.pathname !== '/login') {
+    window.location.assign('/login');
+  }
+};
+
+// Trades the refresh token for a new token pair. Concurrent callers share one
+// request, since a refresh token only works once and a second use revokes the session.
+let refreshing = null;
+const refreshSession = () => {
+  if (!refreshing) {
+    refreshing = (async () => {
+      const refreshToken = localStorage.getItem('refresh_token');
+      const deviceID = localStorage.getItem('device_id');
+      if (!refreshToken || !deviceID) {
+        endSession();
+        return false;
+      }
+
+      try {
+        const response = await fetch(`${API_BASE_URL}/auth/refresh`, {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ refresh_token: refreshToken, device_id: deviceID }),
+        });
+        if (!response.ok) {
+          // Expired, revoked or reused: the session is over either way
+          if (response.status === 401) endSession();
+          return false;
+        }
+        saveSession(await response.json());
+        return true;
+      } catch (error) {
+        console.error('Failed to refresh session:', error);
+        return false;
+      }
+    })().finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+};
+
+// Helper function to make authenticated requests. An expired access token is
+// refreshed and the request retried once.
+const authenticatedRequest = async (url, options = {}, retried = false) => {
   const token = getAuthToken();
-  return fetch(`${API_BASE_URL}${url}`, {
+  const response = await fetch(`${API_BASE_URL}${url}`, {
     ...options,
     headers: {
       'Content-Type': 'application/json',
@@ -16,6 +76,11 @@ const authenticatedRequest = async (url, options = {}) => {
       ...options.headers,
     },
   });
+
+  if (response.status === 401 && !retried && (await refreshSession())) {
+    return authenticatedRequest(url, options, true);
+  }
+  return response;
 };
 
 // Auth functions
@@ -23,7 +88,7 @@ export const register = async (username, email, password) => {
   const response = await fetch(`${API_BASE_URL}/auth/register`, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
-    body: JSON.stringify({ username, email, password }),
+    body: JSON.stringify({ username, email, password, device_id: localStorage.getItem('device_id') || undefined }),
   });
   return response.json();
 };
@@ -32,11 +97,35 @@ export const login = async (username, password) => {
   const response = await fetch(`${API_BASE_URL}/auth/login`, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
-    body: JSON.stringify({ username, password }),
+    body: JSON.stringify({ username, password, device_id: localStorage.getItem('device_id') || undefined }),
   });
   return response.json();
 };
 
+// Second step of a login with two-factor authentication on. Send either the code
+// from the authenticator app or one of the recovery codes.
+export const verifyMFA = async (mfaToken, { code, recoveryCode }) => {
+  const response = await fetch(`${API_BASE_URL}/auth/mfa/verify`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({
+      mfa_token: mfaToken,
+      code: code || undefined,
+      recovery_code: recoveryCode || undefined,
+      device_id: localStorage.getItem('device_id') || undefined,
+    }),
+  });
+  return response.json();
+};
+
+export const logout = async () => {
+  try {
+    await authenticatedRequest('/auth/logout', { method: 'POST' });
+  } finally {
+    clearSession();
+  }
+};
+
 // Posts functions
 export const getAllUserPosts = async () => {
   const response = await authenticatedRequest('/posts');
@@ -84,23 +173,67 @@ export const getFollowingPosts = async (page = 1, limit = 20) => {
 };
 
 // Message functions
-export const sendMessage = async (receiverID, content) => {
+// Retrying with the same clientMsgID returns the first message instead of sending another
+export const sendMessage = async (receiverID, content, clientMsgID) => {
   const response = await authenticatedRequest('/messages', {
     method: 'POST',
-    body: JSON.stringify({ receiver_id: receiverID, content }),
+    body: JSON.stringify({ receiver_id: receiverID, content, client_msg_id: clientMsgID }),
+  });
+  return response.json();
+};
+
+export const editMessage = async (messageID, content) => {
+  const response = await authenticatedRequest(`/messages/${messageID}`, {
+    method: 'PATCH',
+    body: JSON.stringify({ content }),
+  });
+  return response.json();
+};
+
+// forEveryone unsends the message; otherwise it is only removed from your own devices
+export const deleteMessage = async (messageID, forEveryone = false) => {
+  const response = await authenticatedRequest(`/messages/${messageID}?for=${forEveryone ? 'everyone' : 'me'}`, {
+    method: 'DELETE',
+  });
+  return response.json();
+};
+
+export const addReaction = async (messageID, emoji) => {
+  const response = await authenticatedRequest(`/messages/${messageID}/reactions`, {
+    method: 'POST',
+    body: JSON.stringify({ emoji }),
   });
   return response.json();
 };
 
-export const getConversation = async (userID) => {
-  const response = await authenticatedRequest(`/messages/conversation?user_id=${userID}`);
+export const removeReaction = async (messageID, emoji) => {
+  const response = await authenticatedRequest(`/messages/${messageID}/reactions/${encodeURIComponent(emoji)}`, {
+    method: 'DELETE',
+  });
   return response.json();
 };
 
-// WebSocket connection helper
-export const getWebSocketURL = () => {
-  const token = getAuthToken();
-  return `ws://localhost:8080/ws/connect?token=${token}`;
+// Pass before (or after) a message ID to page through older (or newer) messages
+export const getConversation = async (userID, { before, after, limit } = {}) => {
+  const params = new URLSearchParams({ user_id: userID });
+  if (before) params.set('before', before);
+  if (after) params.set('after', after);
+  if (limit) params.set('limit', limit);
+  const response = await authenticatedRequest(`/messages/conversation?${params}`);
+  return response.json();
+};
+
+// WebSocket connection helper - trades the auth token for a single-use ticket
+// so the token never appears in a URL. Pass the last seq seen to resume after it.
+// Like every authenticated request, the ticket request refreshes an expired token.
+export const getWebSocketURL = async (since) => {
+  const response = await authenticatedRequest('/ws/ticket', { method: 'POST' });
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(data.error || 'Failed to get WebSocket ticket');
+  }
+  const url = `ws://localhost:8080/ws/connect?ticket=${encodeURIComponent(data.ticket)}`;
+  return since == null ? url : `${url}&since=${since}`;
 };
 
 // Friends functions
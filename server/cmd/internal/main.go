@@ -3,6 +3,8 @@ package main
 import (
 	"net/http"
 	"log"
+	"os"
+	"strings"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -10,7 +12,9 @@ import (
 	"github.com/joho/godotenv"
 	"gorm.io/gorm"
 
+	"flux/internal/auth"
 	"flux/internal/models"
+	"flux/internal/api/middleware"
 	"flux/internal/api/routes"
 	"flux/internal/chat"
 )
@@ -32,7 +36,7 @@ func initDB() (*gorm.DB, error) {
 	}
 
 	// Auto migrate models
-	err = db.AutoMigrate(&models.User{}, &models.Post{}, &models.Message{}, &models.Friend{})
+	err = db.AutoMigrate(&models.User{}, &models.Post{}, &models.Message{}, &models.Friend{}, &models.Session{}, &models.RefreshToken{}, &models.UserToken{}, &models.RecoveryCode{}, &models.AuthThrottle{}, &models.LockoutEvent{}, &models.UserIdentity{}, &models.OIDCLoginState{}, &models.PersonalAccessToken{}, &models.DataExport{}, &models.WSTicket{}, &models.Conversation{}, &models.ConversationMember{}, &models.UserEvent{}, &models.MessageEdit{}, &models.MessageHide{}, &models.MessageReaction{})
 	if err != nil {
 		return nil, err
 	}
@@ -41,19 +45,45 @@ func initDB() (*gorm.DB, error) {
 
 }
 
+// bootstrapAdmins promotes the comma separated usernames in ADMIN_USERNAMES to admin,
+// which is how the first admin gets created
+func bootstrapAdmins(db *gorm.DB) {
+	for _, username := range strings.Split(os.Getenv("ADMIN_USERNAMES"), ",") {
+		username = strings.TrimSpace(username)
+		if username == "" {
+			continue
+		}
+
+		result := db.Model(&models.User{}).Where("username = ?", username).Update("role", auth.RoleAdmin)
+		if result.Error != nil {
+			log.Printf("Failed to promote %s to admin: %v", username, result.Error)
+		} else if result.RowsAffected == 0 {
+			log.Printf("Warning: ADMIN_USERNAMES lists %s but no such user exists", username)
+		}
+	}
+}
+
 func main() {
+	// Refuse to start without real signing keys
+	keys, err := auth.LoadKeyManagerFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+	auth.UseKeyManager(keys)
+
 	db, err := initDB()
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
+	bootstrapAdmins(db)
 
 	// Initialize router
 	router := gin.Default()
 	
 	// Add CORS middleware
 	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:5173", "http://127.0.0.1:5173"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowOrigins:     middleware.AllowedOrigins(),
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
@@ -0,0 +1,29 @@
+// Command mockoidc is a tiny OpenID Connect provider for local development.
+// It approves every authorization request without a login page, so the
+// Flux OIDC flow can be exercised end to end without a real provider.
+//
+// The identity it returns can be chosen per request by adding sub, email,
+// email_verified and preferred_username to the authorization URL.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"flux/internal/oidc/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", ":8081", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:8081", "issuer URL advertised in discovery")
+	flag.Parse()
+
+	provider, err := oidctest.New(*issuer)
+	if err != nil {
+		log.Fatalf("Failed to start provider: %v", err)
+	}
+
+	log.Printf("Mock OIDC provider listening on %s (issuer %s)", *addr, *issuer)
+	log.Fatal(http.ListenAndServe(*addr, provider))
+}
@@ -0,0 +1,555 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"flux/internal/auth"
+	"flux/internal/cloudinary"
+	"flux/internal/export"
+	"flux/internal/mail"
+	"flux/internal/messaging"
+	"flux/internal/models"
+)
+
+type AccountHandler struct {
+	db                *gorm.DB
+	mailer            mail.Mailer
+	passwordGuard     *auth.Guard
+	cloudinaryService *cloudinary.CloudinaryService
+	exports           *export.Service
+}
+
+// UpdateProfileRequest represents a partial profile update; fields left out are unchanged.
+// Changing the email address needs the current password.
+type UpdateProfileRequest struct {
+	Username        *string `json:"username" binding:"omitempty,min=3,max=50"`
+	Email           *string `json:"email" binding:"omitempty,email"`
+	DisplayName     *string `json:"display_name" binding:"omitempty,max=50"`
+	Bio             *string `json:"bio" binding:"omitempty,max=300"`
+	AvatarURL       *string `json:"avatar_url" binding:"omitempty,max=500"`
+	Website         *string `json:"website" binding:"omitempty,max=200"`
+	HideLastSeen    *bool   `json:"hide_last_seen"`
+	CurrentPassword string  `json:"current_password"`
+}
+
+// ChangePasswordRequest represents the body of a password change
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=6"`
+}
+
+// DeleteAccountRequest represents the body used to confirm account deletion
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+func NewAccountHandler(db *gorm.DB) *AccountHandler {
+	cloudinaryService, err := cloudinary.NewCloudinaryService()
+	if err != nil {
+		// Log the error but don't fail - avatars can still be set by URL
+		fmt.Printf("Warning: Failed to initialize Cloudinary service: %v\n", err)
+		cloudinaryService = nil
+	}
+
+	exports := export.NewService(db, cloudinaryService)
+	exports.Start()
+
+	return &AccountHandler{
+		db:                db,
+		mailer:            loadMailer(),
+		passwordGuard:     auth.NewGuard(db, auth.DefaultGuardConfig),
+		cloudinaryService: cloudinaryService,
+		exports:           exports,
+	}
+}
+
+// validWebURL reports whether raw is an absolute http or https URL
+func validWebURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// checkCurrentPassword verifies a re-entered password, counting failures against the
+// same limit as logins so these endpoints can't be used to guess it instead
+func checkCurrentPassword(c *gin.Context, guard *auth.Guard, user models.User, password string) bool {
+	guardKey := auth.LoginUserKey(user.Username)
+	if wait, blocked := guard.Check(guardKey); blocked {
+		tooManyAttempts(c, wait)
+		return false
+	}
+
+	if err := user.CheckPassword(password); err != nil {
+		guard.Failure(user.Username, c.ClientIP(), guardKey)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return false
+	}
+
+	guard.Success(guardKey)
+	return true
+}
+
+// GetMe - Get the authenticated user's profile
+func (h *AccountHandler) GetMe(c *gin.Context) {
+	user, ok := currentUser(c, h.db)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+// UpdateMe - Update the authenticated user's profile
+func (h *AccountHandler) UpdateMe(c *gin.Context) {
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+		return
+	}
+
+	user, ok := currentUser(c, h.db)
+	if !ok {
+		return
+	}
+
+	updates := map[string]interface{}{}
+
+	if req.Username != nil && *req.Username != user.Username {
+		username := strings.TrimSpace(*req.Username)
+		if len(username) < 3 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Username must be at least 3 characters"})
+			return
+		}
+
+		var count int64
+		h.db.Model(&models.User{}).Where("username = ? AND id != ?", username, user.ID).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+			return
+		}
+		updates["username"] = username
+	}
+
+	emailChanged := false
+	if req.Email != nil && !strings.EqualFold(*req.Email, user.Email) {
+		if *req.Email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Email can't be empty"})
+			return
+		}
+
+		// Whoever controls the email controls password resets, so ask for the password first
+		if req.CurrentPassword == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "current_password is required to change your email"})
+			return
+		}
+		if !checkCurrentPassword(c, h.passwordGuard, user, req.CurrentPassword) {
+			return
+		}
+
+		var count int64
+		h.db.Model(&models.User{}).Where("email = ? AND id != ?", *req.Email, user.ID).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
+			return
+		}
+		updates["email"] = *req.Email
+		updates["email_verified"] = false
+		emailChanged = true
+	}
+
+	if req.DisplayName != nil {
+		updates["display_name"] = strings.TrimSpace(*req.DisplayName)
+	}
+	if req.Bio != nil {
+		updates["bio"] = strings.TrimSpace(*req.Bio)
+	}
+	if req.AvatarURL != nil {
+		if *req.AvatarURL != "" && !validWebURL(*req.AvatarURL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "avatar_url must be an http or https URL"})
+			return
+		}
+		updates["avatar_url"] = *req.AvatarURL
+	}
+	if req.Website != nil {
+		if *req.Website != "" && !validWebURL(*req.Website) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "website must be an http or https URL"})
+			return
+		}
+		updates["website"] = *req.Website
+	}
+
+	if req.HideLastSeen != nil {
+		updates["hide_last_seen"] = *req.HideLastSeen
+	}
+
+	if len(updates) == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "Nothing to update", "user": user})
+		return
+	}
+
+	if err := h.db.Model(&user).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	if emailChanged {
+		if err := sendVerificationEmail(h.db, h.mailer, user); err != nil {
+			fmt.Printf("UpdateMe - Failed to send verification email to user %d: %v\n", user.ID, err)
+		}
+	}
+
+	fmt.Printf("UpdateMe - Updated %d profile fields for user %d\n", len(updates), user.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Profile updated", "user": user})
+}
+
+// UploadAvatar - Upload a new profile picture
+func (h *AccountHandler) UploadAvatar(c *gin.Context) {
+	user, ok := currentUser(c, h.db)
+	if !ok {
+		return
+	}
+
+	if h.cloudinaryService == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Image upload service not available"})
+		return
+	}
+
+	file, header, err := c.Request.FormFile("avatar")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "avatar file is required"})
+		return
+	}
+	defer file.Close()
+
+	// Validate the image file
+	if err := cloudinary.ValidateImageFile(file, header); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image file: " + err.Error()})
+		return
+	}
+
+	avatarURL, err := h.cloudinaryService.UploadImage(file, header, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload image: " + err.Error()})
+		return
+	}
+
+	previous := user.AvatarURL
+	if err := h.db.Model(&user).Update("avatar_url", avatarURL).Error; err != nil {
+		h.cloudinaryService.DeleteImage(avatarURL)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	// Clean up the old avatar if we were hosting it
+	if strings.Contains(previous, "res.cloudinary.com") {
+		if err := h.cloudinaryService.DeleteImage(previous); err != nil {
+			fmt.Printf("Warning: Failed to delete image from Cloudinary: %v\n", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Avatar updated", "user": user})
+}
+
+// ChangePassword - Change the password and log out every other session
+func (h *AccountHandler) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := currentUser(c, h.db)
+	if !ok {
+		return
+	}
+
+	if !checkCurrentPassword(c, h.passwordGuard, user, req.CurrentPassword) {
+		return
+	}
+
+	if err := user.HashPassword(req.NewPassword); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	if err := h.db.Model(&user).Update("password_hash", user.PasswordHash).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	if err := revokeUserSessions(h.db, user.ID, c.GetString("session_jti")); err != nil {
+		fmt.Printf("ChangePassword - Failed to revoke sessions for user %d: %v\n", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed, other sessions have been logged out"})
+}
+
+// DeleteMe - Delete the authenticated user's account along with their posts, messages and follows
+func (h *AccountHandler) DeleteMe(c *gin.Context) {
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := currentUser(c, h.db)
+	if !ok {
+		return
+	}
+
+	if !checkCurrentPassword(c, h.passwordGuard, user, req.Password) {
+		return
+	}
+
+	var imageURLs []string
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		imageURLs, err = deleteUserContent(tx, user)
+		if err != nil {
+			return err
+		}
+
+		return anonymiseUser(tx, user)
+	})
+	if err != nil {
+		fmt.Printf("DeleteMe - Failed to delete user %d: %v\n", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	if err := revokeUserSessions(h.db, user.ID, ""); err != nil {
+		fmt.Printf("DeleteMe - Failed to revoke sessions for user %d: %v\n", user.ID, err)
+	}
+
+	if err := h.exports.RemoveForUser(user.ID); err != nil {
+		fmt.Printf("DeleteMe - Failed to remove exports for user %d: %v\n", user.ID, err)
+	}
+
+	// Images live outside the database, so they go once the rows are gone
+	if h.cloudinaryService != nil {
+		if strings.Contains(user.AvatarURL, "res.cloudinary.com") {
+			imageURLs = append(imageURLs, user.AvatarURL)
+		}
+		for _, imageURL := range imageURLs {
+			if err := h.cloudinaryService.DeleteImage(imageURL); err != nil {
+				fmt.Printf("Warning: Failed to delete image from Cloudinary: %v\n", err)
+			}
+		}
+	}
+
+	fmt.Printf("DeleteMe - Deleted account of user %d\n", user.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted"})
+}
+
+// deleteUserContent removes the user's posts, messages and follows, keeping the
+// counts of the people they followed or were followed by in step. It returns the
+// post image URLs so they can be removed from storage afterwards.
+func deleteUserContent(tx *gorm.DB, user models.User) ([]string, error) {
+	var imageURLs []string
+	if err := tx.Model(&models.Post{}).Where("user_id = ? AND image_url != ''", user.ID).
+		Pluck("image_url", &imageURLs).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.Post{}).Error; err != nil {
+		return nil, err
+	}
+
+	// Edit history, reactions and the events other participants kept about the
+	// messages go with them, and the rows left behind keep no content
+	if err := messaging.WipeMessagesOf(tx, user.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.MessageHide{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.MessageReaction{}).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Where("sender_id = ? OR receiver_id = ?", user.ID, user.ID).Delete(&models.Message{}).Error; err != nil {
+		return nil, err
+	}
+
+	// Events kept for replay carry message contents too
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.UserEvent{}).Error; err != nil {
+		return nil, err
+	}
+
+	// With the messages gone their direct conversations are empty, and the user
+	// leaves their groups
+	var memberships []models.ConversationMember
+	if err := tx.Where("user_id = ?", user.ID).Find(&memberships).Error; err != nil {
+		return nil, err
+	}
+	var directIDs []uint
+	for _, member := range memberships {
+		var conversation models.Conversation
+		if err := tx.First(&conversation, member.ConversationID).Error; err != nil {
+			continue
+		}
+		if !conversation.IsGroup() {
+			directIDs = append(directIDs, conversation.ID)
+		} else if err := leaveGroup(tx, conversation, member); err != nil {
+			return nil, err
+		}
+	}
+	if len(directIDs) > 0 {
+		if err := tx.Unscoped().Where("conversation_id IN ?", directIDs).Delete(&models.ConversationMember{}).Error; err != nil {
+			return nil, err
+		}
+		if err := tx.Unscoped().Where("id IN ?", directIDs).Delete(&models.Conversation{}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// Everyone this user followed loses a follower
+	var followingIDs []uint
+	if err := tx.Model(&models.Friend{}).Where("follower_id = ?", user.ID).Pluck("following_id", &followingIDs).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range followingIDs {
+		if err := tx.Model(&models.User{}).Where("id = ? AND followers_count > 0", id).
+			UpdateColumn("followers_count", gorm.Expr("followers_count - ?", 1)).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// Everyone following this user follows one fewer account
+	var followerIDs []uint
+	if err := tx.Model(&models.Friend{}).Where("following_id = ?", user.ID).Pluck("follower_id", &followerIDs).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range followerIDs {
+		if err := tx.Model(&models.User{}).Where("id = ? AND following_count > 0", id).
+			UpdateColumn("following_count", gorm.Expr("following_count - ?", 1)).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Where("follower_id = ? OR following_id = ?", user.ID, user.ID).Delete(&models.Friend{}).Error; err != nil {
+		return nil, err
+	}
+
+	return imageURLs, nil
+}
+
+// anonymiseUser strips personal data and credentials from the account and soft-deletes it.
+// The username and email are replaced so they can be registered again.
+func anonymiseUser(tx *gorm.DB, user models.User) error {
+	for _, model := range []interface{}{&models.PersonalAccessToken{}, &models.UserIdentity{}, &models.RecoveryCode{}, &models.UserToken{}} {
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+
+	password, _, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+	if err := user.HashPassword(password); err != nil {
+		return err
+	}
+
+	if err := tx.Model(&user).Updates(map[string]interface{}{
+		"username":        fmt.Sprintf("deleted_user_%d", user.ID),
+		"email":           fmt.Sprintf("deleted_user_%d@deleted.invalid", user.ID),
+		"password_hash":   user.PasswordHash,
+		"email_verified":  false,
+		"display_name":    "",
+		"bio":             "",
+		"avatar_url":      "",
+		"website":         "",
+		"totp_secret":     "",
+		"totp_enabled":    false,
+		"role":            auth.RoleUser,
+		"followers_count": 0,
+		"following_count": 0,
+	}).Error; err != nil {
+		return err
+	}
+
+	return tx.Delete(&user).Error
+}
+
+// RequestExport - Start building a copy of the user's data, at most once a day
+func (h *AccountHandler) RequestExport(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	// Failed exports don't count towards the limit
+	var previous models.DataExport
+	err := h.db.Where("user_id = ? AND status != ? AND created_at > ?", userID, models.ExportStatusFailed, time.Now().Add(-export.Interval)).
+		Order("created_at desc").First(&previous).Error
+	if err == nil {
+		wait := time.Until(previous.CreatedAt.Add(export.Interval))
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":  "You can request one export per day",
+			"export": previous,
+		})
+		return
+	}
+
+	job := models.DataExport{
+		UserID: userID.(uint),
+		Status: models.ExportStatusPending,
+	}
+	if err := h.db.Create(&job).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start export"})
+		return
+	}
+
+	if !h.exports.Enqueue(job.ID) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too many exports are being built, please try again later"})
+		return
+	}
+
+	fmt.Printf("RequestExport - Queued export %d for user %d\n", job.ID, job.UserID)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Export started", "export": job})
+}
+
+// GetExport - Get the status of an export, or download it with ?download=true once it's ready
+func (h *AccountHandler) GetExport(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var job models.DataExport
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&job).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+		return
+	}
+
+	if c.Query("download") != "true" {
+		c.JSON(http.StatusOK, gin.H{"export": job})
+		return
+	}
+
+	if job.Status == models.ExportStatusExpired || (job.ExpiresAt != nil && time.Now().After(*job.ExpiresAt)) {
+		c.JSON(http.StatusGone, gin.H{"error": "Export has expired, please request a new one"})
+		return
+	}
+	if job.Status != models.ExportStatusReady {
+		c.JSON(http.StatusConflict, gin.H{"error": "Export is not ready yet", "export": job})
+		return
+	}
+
+	c.FileAttachment(job.FilePath, fmt.Sprintf("flux-export-%s.zip", job.CompletedAt.Format("2006-01-02")))
+}
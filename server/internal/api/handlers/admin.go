@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"flux/internal/auth"
+	"flux/internal/chat"
+	"flux/internal/cloudinary"
+	"flux/internal/mail"
+	"flux/internal/messaging"
+	"flux/internal/models"
+)
+
+// forcedResetTokenTTL gives users longer to act on a reset they didn't ask for
+const forcedResetTokenTTL = 24 * time.Hour
+
+type AdminHandler struct {
+	db                *gorm.DB
+	mailer            mail.Mailer
+	messages          *messaging.Service
+	cloudinaryService *cloudinary.CloudinaryService
+}
+
+// SuspendUserRequest represents the body of a suspension. Without a duration the
+// suspension lasts until the account is unbanned.
+type SuspendUserRequest struct {
+	Reason        string `json:"reason" binding:"required,max=500"`
+	DurationHours int    `json:"duration_hours" binding:"min=0"`
+}
+
+// BanUserRequest represents the body of a ban
+type BanUserRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// UpdateRoleRequest represents the body used to change a user's role
+type UpdateRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+func NewAdminHandler(db *gorm.DB) *AdminHandler {
+	cloudinaryService, err := cloudinary.NewCloudinaryService()
+	if err != nil {
+		// Log the error but don't fail - deleted posts just keep their image
+		fmt.Printf("Warning: Failed to initialize Cloudinary service: %v\n", err)
+		cloudinaryService = nil
+	}
+
+	return &AdminHandler{
+		db:                db,
+		mailer:            loadMailer(),
+		messages:          messaging.NewService(db),
+		cloudinaryService: cloudinaryService,
+	}
+}
+
+// moderationTarget loads the user named in the path and checks the caller may act on them
+func (h *AdminHandler) moderationTarget(c *gin.Context) (models.User, bool) {
+	var target models.User
+
+	targetID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return target, false
+	}
+
+	if uint(targetID) == c.GetUint("user_id") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't moderate your own account"})
+		return target, false
+	}
+
+	if err := h.db.First(&target, targetID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return target, false
+	}
+
+	if !auth.Outranks(c.GetString("user_role"), target.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only moderate users with a lower role"})
+		return target, false
+	}
+
+	return target, true
+}
+
+// ListUsers - Search users by username or email, optionally filtered by role and status
+func (h *AdminHandler) ListUsers(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	query := h.db.Model(&models.User{})
+	if q := c.Query("q"); q != "" {
+		query = query.Where("username LIKE ? OR email LIKE ?", "%"+q+"%", "%"+q+"%")
+	}
+	if role := c.Query("role"); role != "" {
+		query = query.Where("role = ?", role)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search users"})
+		return
+	}
+
+	var users []models.User
+	if err := query.Order("id").Offset((page - 1) * limit).Limit(limit).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users": users,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// GetUser - Get a single user's account details
+func (h *AdminHandler) GetUser(c *gin.Context) {
+	var user models.User
+	if err := h.db.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var activeSessions int64
+	h.db.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Count(&activeSessions)
+
+	c.JSON(http.StatusOK, gin.H{"user": user, "active_sessions": activeSessions})
+}
+
+// SuspendUser - Suspend an account, optionally for a limited time, and log it out everywhere
+func (h *AdminHandler) SuspendUser(c *gin.Context) {
+	var req SuspendUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	target, ok := h.moderationTarget(c)
+	if !ok {
+		return
+	}
+
+	var until *time.Time
+	if req.DurationHours > 0 {
+		t := time.Now().Add(time.Duration(req.DurationHours) * time.Hour)
+		until = &t
+	}
+
+	h.setStatus(c, target, models.UserStatusSuspended, req.Reason, until)
+}
+
+// BanUser - Permanently ban an account and log it out everywhere
+func (h *AdminHandler) BanUser(c *gin.Context) {
+	var req BanUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	target, ok := h.moderationTarget(c)
+	if !ok {
+		return
+	}
+
+	h.setStatus(c, target, models.UserStatusBanned, req.Reason, nil)
+}
+
+// UnbanUser - Lift a suspension or ban
+func (h *AdminHandler) UnbanUser(c *gin.Context) {
+	target, ok := h.moderationTarget(c)
+	if !ok {
+		return
+	}
+
+	// Lifting a ban is as serious as placing one
+	if target.Status == models.UserStatusBanned && !auth.HasPermission(c.GetString("user_role"), auth.PermUsersBan) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to do that"})
+		return
+	}
+
+	h.setStatus(c, target, models.UserStatusActive, "", nil)
+}
+
+// setStatus stores a new account status and revokes the user's sessions unless they're being reinstated
+func (h *AdminHandler) setStatus(c *gin.Context, target models.User, status, reason string, until *time.Time) {
+	if err := h.db.Model(&target).Updates(map[string]interface{}{
+		"status":          status,
+		"status_reason":   reason,
+		"suspended_until": until,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update account status"})
+		return
+	}
+
+	fmt.Printf("Admin - User %d set user %d to %s: %s\n", c.GetUint("user_id"), target.ID, status, reason)
+
+	if status != models.UserStatusActive {
+		if err := revokeUserSessions(h.db, target.ID, ""); err != nil {
+			fmt.Printf("Admin - Failed to revoke sessions for user %d: %v\n", target.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account status updated", "user": target})
+}
+
+// ForcePasswordReset - Lock an account until its owner sets a new password from an emailed link
+func (h *AdminHandler) ForcePasswordReset(c *gin.Context) {
+	target, ok := h.moderationTarget(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.Model(&target).Update("must_reset_password", true).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to force password reset"})
+		return
+	}
+
+	if err := revokeUserSessions(h.db, target.ID, ""); err != nil {
+		fmt.Printf("ForcePasswordReset - Failed to revoke sessions for user %d: %v\n", target.ID, err)
+	}
+
+	token, err := issueUserToken(h.db, target.ID, models.TokenPurposePasswordReset, forcedResetTokenTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reset token"})
+		return
+	}
+
+	body := fmt.Sprintf(
+		"Hi %s,\n\nAn administrator has required a password reset for your Flux account. You won't be able to log in until you choose a new password using the link below:\n\n%s\n\nThe link expires in %d hours. If it expires, use \"Forgot password\" to get a new one.\n",
+		target.Username, appURL("/reset-password", token), int(forcedResetTokenTTL.Hours()),
+	)
+	if err := h.mailer.Send(target.Email, "Reset your Flux password", body); err != nil {
+		fmt.Printf("ForcePasswordReset - Failed to send reset email to user %d: %v\n", target.ID, err)
+	}
+
+	fmt.Printf("Admin - User %d forced a password reset for user %d\n", c.GetUint("user_id"), target.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset required, the user has been emailed a reset link"})
+}
+
+// UpdateRole - Change a user's role. Nobody can hand out a role above their own.
+func (h *AdminHandler) UpdateRole(c *gin.Context) {
+	var req UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !auth.ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role: " + req.Role})
+		return
+	}
+
+	target, ok := h.moderationTarget(c)
+	if !ok {
+		return
+	}
+
+	if auth.Outranks(req.Role, c.GetString("user_role")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can't grant a role above your own"})
+		return
+	}
+
+	if err := h.db.Model(&target).Update("role", req.Role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	fmt.Printf("Admin - User %d changed user %d's role to %s\n", c.GetUint("user_id"), target.ID, req.Role)
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated", "user": target})
+}
+
+// DeletePost - Remove any user's post
+func (h *AdminHandler) DeletePost(c *gin.Context) {
+	var post models.Post
+	if err := h.db.First(&post, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+		return
+	}
+
+	if err := h.db.Delete(&post).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete post"})
+		return
+	}
+
+	// Clean up Cloudinary image if it exists
+	if post.ImageURL != "" && h.cloudinaryService != nil {
+		if err := h.cloudinaryService.DeleteImage(post.ImageURL); err != nil {
+			// Log the error but don't fail the deletion
+			fmt.Printf("Warning: Failed to delete image from Cloudinary: %v\n", err)
+		}
+	}
+
+	fmt.Printf("Admin - User %d deleted post %d by user %d\n", c.GetUint("user_id"), post.ID, post.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "Post deleted successfully"})
+}
+
+// DeleteMessage - Remove any message
+func (h *AdminHandler) DeleteMessage(c *gin.Context) {
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	// Participants see it disappear, and its history and reactions go with it
+	message, err := h.messages.Remove(uint(messageID))
+	if errors.Is(err, messaging.ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
+		return
+	}
+
+	fmt.Printf("Admin - User %d deleted message %d from user %d\n", c.GetUint("user_id"), message.ID, message.SenderID)
+	c.JSON(http.StatusOK, gin.H{"message": "Message deleted successfully"})
+}
+
+// GetWebsocketMetrics - Report open WebSocket connections and why closed ones ended
+func (h *AdminHandler) GetWebsocketMetrics(c *gin.Context) {
+	c.JSON(http.StatusOK, chat.DefaultHub.Metrics())
+}
@@ -1,66 +1,89 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
-	"os"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
+	"flux/internal/auth"
+	"flux/internal/mail"
 	"flux/internal/models"
+	"flux/internal/oidc"
 )
 
+var errRefreshTokenUsed = errors.New("refresh token already used")
+
 type AuthHandler struct {
-	db *gorm.DB
+	db            *gorm.DB
+	mailer        mail.Mailer
+	loginGuard    *auth.Guard
+	registerGuard *auth.Guard
+	providers     map[string]*oidc.Provider
 }
 
 func NewAuthHandler(db *gorm.DB) *AuthHandler {
-	return &AuthHandler{db: db}
+	providers, err := oidc.LoadProvidersFromEnv()
+	if err != nil {
+		// Log the error but don't fail - password logins keep working
+		fmt.Printf("Warning: Failed to load OIDC providers: %v\n", err)
+		providers = map[string]*oidc.Provider{}
+	}
+
+	return &AuthHandler{
+		db:            db,
+		mailer:        loadMailer(),
+		loginGuard:    auth.NewGuard(db, auth.DefaultGuardConfig),
+		registerGuard: auth.NewGuard(db, auth.RegisterGuardConfig),
+		providers:     providers,
+	}
+}
+
+// loadMailer builds the mailer from the environment, falling back to printing mail to stdout
+func loadMailer() mail.Mailer {
+	mailer, err := mail.NewMailerFromEnv()
+	if err != nil {
+		// Log the error but don't fail - fall back to printing mail to stdout
+		fmt.Printf("Warning: Failed to initialize mailer: %v\n", err)
+		mailer = mail.NewLogMailer("", "Flux <no-reply@flux.local>")
+	}
+	return mailer
+}
+
+// dummyPasswordHash is compared against when a username doesn't exist, so the
+// response takes as long as a real password check
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("flux-dummy-password"), bcrypt.DefaultCost)
+
+// tooManyAttempts responds with 429 and a Retry-After header
+func tooManyAttempts(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many attempts, please try again later"})
 }
 
 type RegisterRequest struct {
     Username string `json:"username" binding:"required,min=3,max=50"`
     Email    string `json:"email" binding:"required,email"`
     Password string `json:"password" binding:"required,min=6"`
+    DeviceID string `json:"device_id"`
 }
 
 // LoginRequest represents the user login request body
 type LoginRequest struct {
     Username string `json:"username" binding:"required"`
     Password string `json:"password" binding:"required"`
+    DeviceID string `json:"device_id"`
 }
 
-// JWTClaims represents the claims in the JWT token
-type JWTClaims struct {
-    UserID   uint   `json:"user_id"`
-    Username string `json:"username"`
-    jwt.RegisteredClaims
-}
-
-// Generate JWT token
-func generateJWT(user models.User) (string, error) {
-    // Get the JWT secret key from environment variable or use a default one
-    secretKey := os.Getenv("JWT_SECRET_KEY")
-
-    claims := JWTClaims{
-        UserID:   user.ID,
-        Username: user.Username,
-        RegisteredClaims: jwt.RegisteredClaims{
-            ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-            IssuedAt:  jwt.NewNumericDate(time.Now()),
-        },
-    }
-
-    // Create the token
-    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-    tokenString, err := token.SignedString([]byte(secretKey))
-    if err != nil {
-        return "", err
-    }
-
-    return tokenString, nil
+// RefreshRequest represents the body of a token refresh request
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+	DeviceID     string `json:"device_id" binding:"required"`
 }
 
 func (h *AuthHandler) Register(c *gin.Context) {
@@ -70,6 +93,14 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	// Every registration attempt from an IP counts towards its limit
+	registerKey := auth.RegisterIPKey(c.ClientIP())
+	if wait, blocked := h.registerGuard.Check(registerKey); blocked {
+		tooManyAttempts(c, wait)
+		return
+	}
+	h.registerGuard.Failure(req.Username, c.ClientIP(), registerKey)
+
 	// Checking if username already exists
 	var existingUser models.User
 	if err := h.db.Where("username = ?", req.Username).First(&existingUser).Error; err == nil {
@@ -102,22 +133,26 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := generateJWT(user)
+	// Ask the user to confirm their email address
+	if err := sendVerificationEmail(h.db, h.mailer, user); err != nil {
+		fmt.Printf("Register - Failed to send verification email to user %d: %v\n", user.ID, err)
+	}
+
+	// Start a session for the new user
+	response, err := h.startSession(c, user, req.DeviceID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "User registered successfully",
-		"token": token,
-		"user": gin.H{
-			"id": user.ID,
-			"username": user.Username,
-			"email": user.Email,
-		},
-	})
+	response["message"] = "User registered successfully"
+	response["user"] = gin.H{
+		"id": user.ID,
+		"username": user.Username,
+		"email": user.Email,
+		"email_verified": user.EmailVerified,
+	}
+	c.JSON(http.StatusCreated, response)
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -127,33 +162,190 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	// Throttle by both username and IP; unknown usernames are tracked the same way
+	ip := c.ClientIP()
+	guardKeys := []string{auth.LoginUserKey(req.Username), auth.LoginIPKey(ip)}
+	if wait, blocked := h.loginGuard.Check(guardKeys...); blocked {
+		tooManyAttempts(c, wait)
+		return
+	}
+
 	// Find the user by username
 	var user models.User
 	if err := h.db.Where("username = ?", req.Username).First(&user).Error; err != nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+		h.loginGuard.Failure(req.Username, ip, guardKeys...)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
 		return
 	}
 
 	// Check password
 	if err := user.CheckPassword(req.Password); err != nil {
+		h.loginGuard.Failure(req.Username, ip, guardKeys...)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
 		return
 	}
 
-	// Generate JWT token
-	token, err := generateJWT(user)
+	// Only the account's counter is cleared; logging into an account of your own
+	// mustn't reset the limit on guessing other people's passwords from this IP
+	h.loginGuard.Success(auth.LoginUserKey(req.Username))
+
+	h.completeLogin(c, user, req.DeviceID)
+}
+
+// completeLogin starts a session for a user whose first factor checked out,
+// or hands back an mfa_pending token when 2FA is enabled
+func (h *AuthHandler) completeLogin(c *gin.Context, user models.User, deviceID string) {
+	if loginBlocked(c, user) {
+		return
+	}
+
+	// With 2FA on, the first factor alone only earns a short-lived mfa_pending token
+	if user.TOTPEnabled {
+		mfaToken, err := auth.GenerateMFAToken(user.ID, user.Username)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":      "Two-factor authentication required",
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"expires_in":   int(auth.MFATokenTTL.Seconds()),
+		})
+		return
+	}
+
+	// Start a session for this device
+	response, err := h.startSession(c, user, deviceID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
+	response["message"] = "Login successful"
+	response["user"] = gin.H{
+		"id": user.ID,
+		"username": user.Username,
+		"email": user.Email,
+		"email_verified": user.EmailVerified,
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Refresh exchanges a refresh token for a new token pair, rotating the refresh token
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var stored models.RefreshToken
+	if err := h.db.Preload("Session").Where("token_hash = ?", auth.HashToken(req.RefreshToken)).First(&stored).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	session := stored.Session
+
+	if session.RevokedAt != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+		return
+	}
+
+	// A rotated token being presented again means it was copied, so kill the whole session
+	if stored.RotatedAt != nil {
+		fmt.Printf("Refresh token reuse detected for user %d, revoking session %d\n", session.UserID, session.ID)
+		if err := revokeSessions(h.db, []models.Session{session}); err != nil {
+			fmt.Printf("Failed to revoke session %d: %v\n", session.ID, err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, session revoked"})
+		return
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token expired"})
+		return
+	}
+
+	if session.DeviceID != req.DeviceID {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token was issued to another device"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, session.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	if loginBlocked(c, user) {
+		return
+	}
+
+	var accessToken, refreshToken string
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Mark the presented token as used, guarding against a concurrent rotation
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND rotated_at IS NULL", stored.ID).
+			Update("rotated_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRefreshTokenUsed
+		}
+
+		if err := tx.Model(&session).Updates(map[string]interface{}{
+			"last_seen_at": time.Now(),
+			"ip":           c.ClientIP(),
+			"user_agent":   c.Request.UserAgent(),
+		}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		accessToken, refreshToken, err = issueTokens(tx, user, session)
+		return err
+	})
+	if err == errRefreshTokenUsed {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token already used"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Login successful",
-		"token": token,
-		"user": gin.H{
-			"id": user.ID,
-			"username": user.Username,
-			"email": user.Email,
-		},
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"device_id":     session.DeviceID,
+		"expires_in":    int(auth.AccessTokenTTL.Seconds()),
 	})
-}
\ No newline at end of file
+}
+
+// Logout revokes the session the current access token belongs to
+func (h *AuthHandler) Logout(c *gin.Context) {
+	sessionJTI, exists := c.Get("session_jti")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var session models.Session
+	if err := h.db.Where("jti = ?", sessionJTI).First(&session).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session not found"})
+		return
+	}
+
+	if err := revokeSessions(h.db, []models.Session{session}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"flux/internal/auth"
+	"flux/internal/models"
+)
+
+func newTestAuthHandler(t *testing.T) *AuthHandler {
+	t.Helper()
+
+	useTestKeys(t)
+	db := newTestDB(t)
+	return &AuthHandler{
+		db:            db,
+		loginGuard:    auth.NewGuard(db, auth.DefaultGuardConfig),
+		registerGuard: auth.NewGuard(db, auth.RegisterGuardConfig),
+	}
+}
+
+// login starts a session for the user and returns its refresh token and device ID
+func login(t *testing.T, h *AuthHandler, user models.User) (string, string) {
+	t.Helper()
+
+	var response gin.H
+	serve(t, func(c *gin.Context) {
+		var err error
+		if response, err = h.startSession(c, user, ""); err != nil {
+			t.Fatalf("start session: %v", err)
+		}
+	}, http.MethodPost, 0, nil)
+	return response["refresh_token"].(string), response["device_id"].(string)
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	h := newTestAuthHandler(t)
+	user := newTestUser(t, h.db, "alice", "password123")
+	refreshToken, deviceID := login(t, h, user)
+
+	w := serve(t, h.Refresh, http.MethodPost, 0, RefreshRequest{RefreshToken: refreshToken, DeviceID: deviceID})
+	expectStatus(t, w, http.StatusOK)
+
+	rotated := decode(t, w)["refresh_token"].(string)
+	if rotated == refreshToken {
+		t.Fatal("refresh returned the same refresh token")
+	}
+
+	w = serve(t, h.Refresh, http.MethodPost, 0, RefreshRequest{RefreshToken: rotated, DeviceID: deviceID})
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	h := newTestAuthHandler(t)
+	user := newTestUser(t, h.db, "alice", "password123")
+	stolen, deviceID := login(t, h, user)
+
+	w := serve(t, h.Refresh, http.MethodPost, 0, RefreshRequest{RefreshToken: stolen, DeviceID: deviceID})
+	expectStatus(t, w, http.StatusOK)
+	current := decode(t, w)["refresh_token"].(string)
+
+	// Presenting the rotated token again revokes the whole session
+	w = serve(t, h.Refresh, http.MethodPost, 0, RefreshRequest{RefreshToken: stolen, DeviceID: deviceID})
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	var session models.Session
+	if err := h.db.Where("user_id = ?", user.ID).First(&session).Error; err != nil {
+		t.Fatalf("load session: %v", err)
+	}
+	if session.RevokedAt == nil {
+		t.Fatal("session was not revoked after refresh token reuse")
+	}
+
+	// So the legitimate holder's newer token stops working too
+	w = serve(t, h.Refresh, http.MethodPost, 0, RefreshRequest{RefreshToken: current, DeviceID: deviceID})
+	expectStatus(t, w, http.StatusUnauthorized)
+}
+
+func TestRefreshRejectsOtherDevice(t *testing.T) {
+	h := newTestAuthHandler(t)
+	user := newTestUser(t, h.db, "alice", "password123")
+	refreshToken, _ := login(t, h, user)
+
+	w := serve(t, h.Refresh, http.MethodPost, 0, RefreshRequest{RefreshToken: refreshToken, DeviceID: "someone-else"})
+	expectStatus(t, w, http.StatusUnauthorized)
+}
+
+func TestLoginSuccessKeepsIPThrottle(t *testing.T) {
+	h := newTestAuthHandler(t)
+	newTestUser(t, h.db, "victim", "password123")
+	newTestUser(t, h.db, "sprayer", "password123")
+
+	// Guesses against one account followed by a login to another from the same IP
+	for i := 0; i < auth.DefaultGuardConfig.BackoffAfter; i++ {
+		w := serve(t, h.Login, http.MethodPost, 0, LoginRequest{Username: "victim", Password: "guess"})
+		expectStatus(t, w, http.StatusUnauthorized)
+	}
+	w := serve(t, h.Login, http.MethodPost, 0, LoginRequest{Username: "sprayer", Password: "password123"})
+	expectStatus(t, w, http.StatusOK)
+
+	var ipThrottle models.AuthThrottle
+	if err := h.db.Where("key = ?", auth.LoginIPKey("192.0.2.1")).First(&ipThrottle).Error; err != nil {
+		t.Fatalf("IP throttle was reset by a successful login: %v", err)
+	}
+	if ipThrottle.Failures != auth.DefaultGuardConfig.BackoffAfter {
+		t.Fatalf("IP failures = %d, want %d", ipThrottle.Failures, auth.DefaultGuardConfig.BackoffAfter)
+	}
+
+	var userThrottles int64
+	h.db.Model(&models.AuthThrottle{}).Where("key = ?", auth.LoginUserKey("sprayer")).Count(&userThrottles)
+	if userThrottles != 0 {
+		t.Fatal("the account's own throttle was not cleared")
+	}
+}
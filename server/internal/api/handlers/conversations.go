@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"flux/internal/chat"
+	"flux/internal/messaging"
+	"flux/internal/models"
+)
+
+// previewLength is how many characters of the last message the inbox shows
+const previewLength = 100
+
+type ConversationHandler struct {
+	db *gorm.DB
+}
+
+// UpdateConversationRequest changes the caller's own settings for a conversation;
+// fields left out are unchanged. Renaming a group needs an owner or admin.
+type UpdateConversationRequest struct {
+	Name     *string `json:"name" binding:"omitempty,max=100"`
+	Muted    *bool   `json:"muted"`
+	Archived *bool   `json:"archived"`
+	Pinned   *bool   `json:"pinned"`
+}
+
+// MessagePreview is the shortened last message shown in the inbox
+type MessagePreview struct {
+	ID        uint      `json:"id"`
+	SenderID  uint      `json:"sender_id"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ConversationSummary is one row of the inbox
+type ConversationSummary struct {
+	ID            uint                `json:"id"`
+	Kind          string              `json:"kind"`
+	Name          string              `json:"name,omitempty"`
+	Partner       *models.UserSummary `json:"partner,omitempty"` // direct conversations only
+	LastMessage   *MessagePreview     `json:"last_message"`
+	LastMessageAt *time.Time          `json:"last_message_at"`
+	UnreadCount   int                 `json:"unread_count"`
+	Muted         bool                `json:"muted"`
+	Archived      bool                `json:"archived"`
+	Pinned        bool                `json:"pinned"`
+}
+
+func NewConversationHandler(db *gorm.DB) *ConversationHandler {
+	backfillConversations(db)
+	return &ConversationHandler{db: db}
+}
+
+// backfillConversations files messages sent before conversations existed into them
+func backfillConversations(db *gorm.DB) {
+	// Members from before groups existed were there from the start
+	if err := db.Model(&models.ConversationMember{}).Where("joined_at IS NULL").
+		Update("joined_at", gorm.Expr("created_at")).Error; err != nil {
+		fmt.Printf("Warning: Failed to set joined_at on existing conversation members: %v\n", err)
+	}
+
+	type pair struct {
+		SenderID   uint
+		ReceiverID uint
+	}
+
+	var pairs []pair
+	if err := db.Unscoped().Model(&models.Message{}).Distinct("sender_id", "receiver_id").
+		Where("conversation_id IS NULL OR conversation_id = 0").Find(&pairs).Error; err != nil {
+		fmt.Printf("Warning: Failed to look for messages without a conversation: %v\n", err)
+		return
+	}
+	if len(pairs) == 0 {
+		return
+	}
+
+	touched := map[uint]bool{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, p := range pairs {
+			conversation, err := messaging.DirectConversation(tx, p.SenderID, p.ReceiverID)
+			if err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Model(&models.Message{}).
+				Where("sender_id = ? AND receiver_id = ? AND (conversation_id IS NULL OR conversation_id = 0)", p.SenderID, p.ReceiverID).
+				Update("conversation_id", conversation.ID).Error; err != nil {
+				return err
+			}
+			touched[conversation.ID] = true
+		}
+
+		for id := range touched {
+			if err := messaging.RefreshConversation(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Warning: Failed to build conversations for existing messages: %v\n", err)
+		return
+	}
+	fmt.Printf("Built %d conversations for existing messages\n", len(touched))
+}
+
+// ListConversations - List the user's conversations, pinned first and then by latest
+// message, or by when it was created for a group nobody has written in yet
+func (h *ConversationHandler) ListConversations(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	archived := c.Query("archived") == "true"
+
+	query := h.db.Model(&models.ConversationMember{}).
+		Joins("JOIN conversations ON conversations.id = conversation_members.conversation_id AND conversations.deleted_at IS NULL").
+		Where("conversation_members.user_id = ? AND conversation_members.archived = ?", userID, archived).
+		// A group shows up as soon as it is created; a direct conversation once it has messages
+		Where("conversations.last_message_id IS NOT NULL OR conversations.kind = ?", models.ConversationGroup)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversations"})
+		return
+	}
+
+	var memberships []models.ConversationMember
+	if err := query.Order("conversation_members.pinned DESC, COALESCE(conversations.last_message_at, conversations.created_at) DESC").
+		Offset((page - 1) * limit).Limit(limit).Find(&memberships).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversations"})
+		return
+	}
+
+	conversationIDs := make([]uint, 0, len(memberships))
+	for _, m := range memberships {
+		conversationIDs = append(conversationIDs, m.ConversationID)
+	}
+
+	var conversations []models.Conversation
+	var partners []models.ConversationMember
+	if len(conversationIDs) > 0 {
+		if err := h.db.Where("id IN ?", conversationIDs).Find(&conversations).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversations"})
+			return
+		}
+		if err := h.db.Preload("User").
+			Joins("JOIN conversations ON conversations.id = conversation_members.conversation_id AND conversations.kind = ?", models.ConversationDirect).
+			Where("conversation_members.conversation_id IN ? AND conversation_members.user_id != ?", conversationIDs, userID).
+			Find(&partners).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversations"})
+			return
+		}
+	}
+
+	var me models.User
+	h.db.First(&me, userID)
+
+	lastMessageIDs := []uint{}
+	byConversation := make(map[uint]models.Conversation, len(conversations))
+	for _, conversation := range conversations {
+		byConversation[conversation.ID] = conversation
+		if conversation.LastMessageID != nil {
+			lastMessageIDs = append(lastMessageIDs, *conversation.LastMessageID)
+		}
+	}
+
+	partnerOf := make(map[uint]models.UserSummary, len(partners))
+	for _, p := range partners {
+		partnerOf[p.ConversationID] = p.User.Summary()
+	}
+
+	var lastMessages []models.Message
+	if len(lastMessageIDs) > 0 {
+		h.db.Where("id IN ?", lastMessageIDs).Find(&lastMessages)
+	}
+	previews := make(map[uint]*MessagePreview, len(lastMessages))
+	for _, m := range lastMessages {
+		previews[m.ID] = &MessagePreview{ID: m.ID, SenderID: m.SenderID, Content: messaging.Truncate(m.Content, previewLength), CreatedAt: m.CreatedAt}
+	}
+
+	summaries := make([]ConversationSummary, 0, len(memberships))
+	for _, m := range memberships {
+		conversation := byConversation[m.ConversationID]
+		summary := ConversationSummary{
+			ID:            m.ConversationID,
+			Kind:          conversation.Kind,
+			Name:          conversation.Name,
+			LastMessageAt: conversation.LastMessageAt,
+			UnreadCount:   m.UnreadCount,
+			Muted:         m.Muted,
+			Archived:      m.Archived,
+			Pinned:        m.Pinned,
+		}
+
+		// A conversation with yourself has no other member
+		if !conversation.IsGroup() {
+			partner, ok := partnerOf[m.ConversationID]
+			if !ok {
+				partner = me.Summary()
+			}
+			summary.Partner = &partner
+		}
+
+		if conversation.LastMessageID != nil {
+			summary.LastMessage = previews[*conversation.LastMessageID]
+		}
+		summaries = append(summaries, summary)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"conversations": summaries,
+		"total":         total,
+		"page":          page,
+		"limit":         limit,
+	})
+}
+
+// UpdateConversation - Mute, archive or pin a conversation for the authenticated user
+func (h *ConversationHandler) UpdateConversation(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req UpdateConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+		return
+	}
+
+	conversation, member, ok := h.membership(c, userID.(uint))
+	if !ok {
+		return
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if !conversation.IsGroup() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only groups have a name"})
+			return
+		}
+		if !member.CanManage() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only owners and admins can rename the group"})
+			return
+		}
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Group name can't be empty"})
+			return
+		}
+
+		if name != conversation.Name {
+			if err := h.db.Model(&conversation).Update("name", name).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename group"})
+				return
+			}
+			h.notifyMembers(conversation.ID, nil, chat.ConversationEventPayload{
+				ConversationID: conversation.ID,
+				Action:         chat.ConversationRenamed,
+				ActorID:        member.UserID,
+				Name:           name,
+			})
+		}
+	}
+
+	updates := map[string]interface{}{}
+	if req.Muted != nil {
+		updates["muted"] = *req.Muted
+	}
+	if req.Archived != nil {
+		updates["archived"] = *req.Archived
+	}
+	if req.Pinned != nil {
+		updates["pinned"] = *req.Pinned
+	}
+
+	if len(updates) > 0 {
+		if err := h.db.Model(&member).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update conversation"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Conversation updated", "conversation": conversation, "membership": member})
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"flux/internal/messaging"
+	"flux/internal/models"
+)
+
+func TestListConversationsShowsEmptyGroups(t *testing.T) {
+	db := newTestDB(t)
+	h := &ConversationHandler{db: db}
+	alice := newTestUser(t, db, "alice", "password123")
+	bob := newTestUser(t, db, "bob", "password123")
+	carol := newTestUser(t, db, "carol", "password123")
+
+	// A direct conversation with a message, then a direct one without and a new group
+	talked, err := messaging.DirectConversation(db, alice.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("create conversation: %v", err)
+	}
+	message := models.Message{SenderID: bob.ID, ReceiverID: alice.ID, ConversationID: talked.ID, Content: "hi"}
+	if err := db.Create(&message).Error; err != nil {
+		t.Fatalf("create message: %v", err)
+	}
+	if err := messaging.RefreshConversation(db, talked.ID); err != nil {
+		t.Fatalf("refresh conversation: %v", err)
+	}
+	if _, err := messaging.DirectConversation(db, alice.ID, carol.ID); err != nil {
+		t.Fatalf("create conversation: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	group := newTestGroup(t, db, alice, bob, carol)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/conversations", nil)
+	c.Set("user_id", alice.ID)
+	h.ListConversations(c)
+	expectStatus(t, w, http.StatusOK)
+
+	body := decode(t, w)
+	var got []uint
+	for _, summary := range body["conversations"].([]interface{}) {
+		got = append(got, uint(summary.(map[string]interface{})["id"].(float64)))
+	}
+	// The group was created after the last message, so it comes first
+	if len(got) != 2 || got[0] != group.ID || got[1] != talked.ID {
+		t.Fatalf("inbox lists conversations %v, want [%d %d]", got, group.ID, talked.ID)
+	}
+	if total := body["total"].(float64); total != 2 {
+		t.Fatalf("total = %v, want 2", total)
+	}
+}
+
+func TestListMessagesMarksDirectMessagesDelivered(t *testing.T) {
+	db := newTestDB(t)
+	h := &ConversationHandler{db: db}
+	alice := newTestUser(t, db, "alice", "password123")
+	bob := newTestUser(t, db, "bob", "password123")
+
+	direct, err := messaging.DirectConversation(db, alice.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("create conversation: %v", err)
+	}
+	toAlice := models.Message{SenderID: bob.ID, ReceiverID: alice.ID, ConversationID: direct.ID, Content: "hi"}
+	toBob := models.Message{SenderID: alice.ID, ReceiverID: bob.ID, ConversationID: direct.ID, Content: "hello"}
+	for _, message := range []*models.Message{&toAlice, &toBob} {
+		if err := db.Create(message).Error; err != nil {
+			t.Fatalf("create message: %v", err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/conversations/1/messages", nil)
+	c.Params = gin.Params{{Key: "id", Value: strconv.FormatUint(uint64(direct.ID), 10)}}
+	c.Set("user_id", alice.ID)
+	h.ListMessages(c)
+	expectStatus(t, w, http.StatusOK)
+
+	var listed bool
+	for _, view := range decode(t, w)["messages"].([]interface{}) {
+		message := view.(map[string]interface{})
+		if message["ID"] == float64(toAlice.ID) {
+			listed = message["delivered_at"] != nil
+		}
+	}
+	if !listed {
+		t.Fatal("the listed message doesn't show it was delivered")
+	}
+
+	db.First(&toAlice, toAlice.ID)
+	db.First(&toBob, toBob.ID)
+	if toAlice.DeliveredAt == nil {
+		t.Fatal("loading the conversation didn't mark alice's message delivered")
+	}
+	if toBob.DeliveredAt != nil {
+		t.Fatal("loading the conversation marked the message alice sent as delivered")
+	}
+}
+
+func TestListMessagesWithholdsQuotesFromBeforeJoining(t *testing.T) {
+	db := newTestDB(t)
+	h := &ConversationHandler{db: db}
+	alice := newTestUser(t, db, "alice", "password123")
+	bob := newTestUser(t, db, "bob", "password123")
+	carol := newTestUser(t, db, "carol", "password123")
+	group := newTestGroup(t, db, alice, bob)
+
+	earlier := models.Message{SenderID: alice.ID, ConversationID: group.ID, Content: "before carol"}
+	if err := db.Create(&earlier).Error; err != nil {
+		t.Fatalf("create message: %v", err)
+	}
+	member := messaging.NewMember(group.ID, carol.ID, models.MemberRoleMember)
+	member.JoinedAt = earlier.CreatedAt.Add(time.Second)
+	if err := db.Create(&member).Error; err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	reply := models.Message{SenderID: bob.ID, ConversationID: group.ID, Content: "agreed", ReplyToID: &earlier.ID,
+		CreatedAt: member.JoinedAt.Add(time.Second)}
+	if err := db.Create(&reply).Error; err != nil {
+		t.Fatalf("create reply: %v", err)
+	}
+
+	list := func(user models.User) map[string]interface{} {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/conversations/1/messages", nil)
+		c.Params = gin.Params{{Key: "id", Value: strconv.FormatUint(uint64(group.ID), 10)}}
+		c.Set("user_id", user.ID)
+		h.ListMessages(c)
+		expectStatus(t, w, http.StatusOK)
+
+		for _, view := range decode(t, w)["messages"].([]interface{}) {
+			message := view.(map[string]interface{})
+			if message["ID"] == float64(reply.ID) {
+				return message["reply_to"].(map[string]interface{})
+			}
+		}
+		t.Fatalf("user %d doesn't see the reply", user.ID)
+		return nil
+	}
+
+	if quote := list(bob); quote["content"] != "before carol" {
+		t.Fatalf("bob sees the reply quoting %v, want the earlier message", quote)
+	}
+	if quote := list(carol); quote["content"] != "" || quote["before_joined"] != true {
+		t.Fatalf("carol sees the reply quoting %v, want it withheld", quote)
+	}
+}
@@ -0,0 +1,564 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"flux/internal/chat"
+	"flux/internal/messaging"
+	"flux/internal/models"
+)
+
+// maxGroupMembers caps how many people a group can have
+const maxGroupMembers = 256
+
+// CreateGroupRequest represents the body used to start a group; the creator is
+// added as its owner
+type CreateGroupRequest struct {
+	Name      string `json:"name" binding:"required,max=100"`
+	MemberIDs []uint `json:"member_ids"`
+}
+
+// AddMembersRequest represents the users to add to a group
+type AddMembersRequest struct {
+	UserIDs []uint `json:"user_ids" binding:"required"`
+}
+
+// UpdateMemberRoleRequest represents a role change. Making someone the owner hands
+// the group over to them and makes the old owner an admin.
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// ConversationReadRequest represents a read receipt for a conversation
+type ConversationReadRequest struct {
+	UpToID uint `json:"up_to_id" binding:"required"`
+}
+
+// MemberView is a member as listed in a conversation's details
+type MemberView struct {
+	User     models.UserSummary `json:"user"`
+	Role     string             `json:"role"`
+	JoinedAt time.Time          `json:"joined_at"`
+}
+
+// membership loads the conversation in the :id parameter and the user's membership
+// of it, answering 404 if either doesn't exist
+func (h *ConversationHandler) membership(c *gin.Context, userID uint) (models.Conversation, models.ConversationMember, bool) {
+	var conversation models.Conversation
+	var member models.ConversationMember
+
+	if err := h.db.First(&conversation, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return conversation, member, false
+	}
+	if err := h.db.Where("conversation_id = ? AND user_id = ?", conversation.ID, userID).First(&member).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return conversation, member, false
+	}
+	return conversation, member, true
+}
+
+// groupManager is membership for endpoints that change who is in a group: it also
+// checks the conversation is a group and the user is one of its owners or admins
+func (h *ConversationHandler) groupManager(c *gin.Context, userID uint) (models.Conversation, models.ConversationMember, bool) {
+	conversation, member, ok := h.membership(c, userID)
+	if !ok {
+		return conversation, member, false
+	}
+	if !conversation.IsGroup() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Direct conversations don't have members to manage"})
+		return conversation, member, false
+	}
+	if !member.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners and admins can manage members"})
+		return conversation, member, false
+	}
+	return conversation, member, true
+}
+
+// notifyMembers sends a conversation.updated event to everyone in the conversation
+// and to extra users, such as someone who was just removed
+func (h *ConversationHandler) notifyMembers(conversationID uint, extra []uint, payload chat.ConversationEventPayload) {
+	var memberIDs []uint
+	h.db.Model(&models.ConversationMember{}).Where("conversation_id = ?", conversationID).Pluck("user_id", &memberIDs)
+
+	chat.DefaultHub.PublishMany(append(memberIDs, extra...), chat.NewEnvelope(chat.EventConversation, "", payload))
+}
+
+// existingUsers returns which of ids belong to real users, without duplicates
+func existingUsers(db *gorm.DB, ids []uint) ([]uint, error) {
+	var found []uint
+	if len(ids) == 0 {
+		return found, nil
+	}
+	err := db.Model(&models.User{}).Where("id IN ?", ids).Pluck("id", &found).Error
+	return found, err
+}
+
+// leaveGroup removes a member from a group. An owner who leaves hands the group to
+// the longest-standing admin, or failing that member, and a group nobody is left in
+// is deleted.
+func leaveGroup(tx *gorm.DB, conversation models.Conversation, member models.ConversationMember) error {
+	if err := tx.Unscoped().Delete(&member).Error; err != nil {
+		return err
+	}
+
+	var remaining []models.ConversationMember
+	if err := tx.Where("conversation_id = ?", conversation.ID).Order("joined_at asc, id asc").Find(&remaining).Error; err != nil {
+		return err
+	}
+	if len(remaining) == 0 {
+		return tx.Delete(&conversation).Error
+	}
+
+	if member.Role == models.MemberRoleOwner {
+		successor := remaining[0]
+		for _, m := range remaining {
+			if m.Role == models.MemberRoleAdmin {
+				successor = m
+				break
+			}
+		}
+		if err := tx.Model(&successor).Update("role", models.MemberRoleOwner).Error; err != nil {
+			return err
+		}
+	}
+	return messaging.RefreshConversation(tx, conversation.ID)
+}
+
+// CreateGroup - Create a group conversation with the authenticated user as owner
+func (h *ConversationHandler) CreateGroup(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+	ownerID := userID.(uint)
+
+	var req CreateGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Group name can't be empty"})
+		return
+	}
+
+	memberIDs, err := existingUsers(h.db, req.MemberIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify members"})
+		return
+	}
+	if len(memberIDs)+1 > maxGroupMembers {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A group can have at most %d members", maxGroupMembers)})
+		return
+	}
+
+	conversation := models.Conversation{Kind: models.ConversationGroup, Name: name, CreatedByID: ownerID}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&conversation).Error; err != nil {
+			return err
+		}
+
+		members := []models.ConversationMember{messaging.NewMember(conversation.ID, ownerID, models.MemberRoleOwner)}
+		for _, id := range memberIDs {
+			if id != ownerID {
+				members = append(members, messaging.NewMember(conversation.ID, id, models.MemberRoleMember))
+			}
+		}
+		return tx.Create(&members).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group"})
+		return
+	}
+
+	h.notifyMembers(conversation.ID, nil, chat.ConversationEventPayload{
+		ConversationID: conversation.ID,
+		Action:         chat.ConversationCreated,
+		ActorID:        ownerID,
+		Name:           conversation.Name,
+	})
+
+	fmt.Printf("CreateGroup - User %d created group %d with %d other members\n", ownerID, conversation.ID, len(memberIDs))
+	c.JSON(http.StatusCreated, gin.H{"message": "Group created", "conversation": conversation})
+}
+
+// GetConversationDetails - Get a conversation with its members and the user's own settings
+func (h *ConversationHandler) GetConversationDetails(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	conversation, member, ok := h.membership(c, userID.(uint))
+	if !ok {
+		return
+	}
+
+	var members []models.ConversationMember
+	if err := h.db.Preload("User").Where("conversation_id = ?", conversation.ID).
+		Order("joined_at asc, id asc").Find(&members).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch members"})
+		return
+	}
+
+	views := make([]MemberView, 0, len(members))
+	for _, m := range members {
+		views = append(views, MemberView{User: m.User.Summary(), Role: m.Role, JoinedAt: m.JoinedAt})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"conversation": conversation,
+		"members":      views,
+		"membership":   member,
+	})
+}
+
+// AddMembers - Add users to a group; they see messages from now on
+func (h *ConversationHandler) AddMembers(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req AddMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+		return
+	}
+
+	conversation, actor, ok := h.groupManager(c, userID.(uint))
+	if !ok {
+		return
+	}
+
+	ids, err := existingUsers(h.db, req.UserIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify members"})
+		return
+	}
+
+	var current []uint
+	h.db.Model(&models.ConversationMember{}).Where("conversation_id = ?", conversation.ID).Pluck("user_id", &current)
+	isMember := make(map[uint]bool, len(current))
+	for _, id := range current {
+		isMember[id] = true
+	}
+
+	var added []models.ConversationMember
+	var addedIDs []uint
+	for _, id := range ids {
+		if !isMember[id] {
+			added = append(added, messaging.NewMember(conversation.ID, id, models.MemberRoleMember))
+			addedIDs = append(addedIDs, id)
+		}
+	}
+
+	if len(added) == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "Nothing to add", "added": []uint{}})
+		return
+	}
+	if len(current)+len(added) > maxGroupMembers {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A group can have at most %d members", maxGroupMembers)})
+		return
+	}
+
+	if err := h.db.Create(&added).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add members"})
+		return
+	}
+
+	h.notifyMembers(conversation.ID, nil, chat.ConversationEventPayload{
+		ConversationID: conversation.ID,
+		Action:         chat.ConversationMembersAdded,
+		ActorID:        actor.UserID,
+		UserIDs:        addedIDs,
+	})
+
+	fmt.Printf("AddMembers - User %d added %d members to group %d\n", actor.UserID, len(added), conversation.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Members added", "added": addedIDs})
+}
+
+// RemoveMember - Remove someone from a group. Admins can only remove members; the owner can remove anyone.
+func (h *ConversationHandler) RemoveMember(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	conversation, actor, ok := h.groupManager(c, userID.(uint))
+	if !ok {
+		return
+	}
+
+	targetID, err := strconv.ParseUint(c.Param("user_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if uint(targetID) == actor.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use leave to remove yourself"})
+		return
+	}
+
+	var target models.ConversationMember
+	if err := h.db.Where("conversation_id = ? AND user_id = ?", conversation.ID, uint(targetID)).First(&target).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	if actor.Role != models.MemberRoleOwner && target.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can remove admins"})
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		return leaveGroup(tx, conversation, target)
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+
+	h.notifyMembers(conversation.ID, []uint{target.UserID}, chat.ConversationEventPayload{
+		ConversationID: conversation.ID,
+		Action:         chat.ConversationMemberRemoved,
+		ActorID:        actor.UserID,
+		UserIDs:        []uint{target.UserID},
+	})
+
+	fmt.Printf("RemoveMember - User %d removed user %d from group %d\n", actor.UserID, target.UserID, conversation.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
+
+// UpdateMemberRole - Make a member an admin or member, or hand the group to them (owner only)
+func (h *ConversationHandler) UpdateMemberRole(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req UpdateMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+		return
+	}
+	if req.Role != models.MemberRoleOwner && req.Role != models.MemberRoleAdmin && req.Role != models.MemberRoleMember {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be owner, admin or member"})
+		return
+	}
+
+	conversation, actor, ok := h.groupManager(c, userID.(uint))
+	if !ok {
+		return
+	}
+	if actor.Role != models.MemberRoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can change roles"})
+		return
+	}
+
+	var target models.ConversationMember
+	if err := h.db.Where("conversation_id = ? AND user_id = ?", conversation.ID, c.Param("user_id")).First(&target).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	if target.UserID == actor.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't change your own role, make someone else the owner instead"})
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if req.Role == models.MemberRoleOwner {
+			if err := tx.Model(&actor).Update("role", models.MemberRoleAdmin).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&target).Update("role", req.Role).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change role"})
+		return
+	}
+
+	h.notifyMembers(conversation.ID, nil, chat.ConversationEventPayload{
+		ConversationID: conversation.ID,
+		Action:         chat.ConversationRoleChanged,
+		ActorID:        actor.UserID,
+		UserIDs:        []uint{target.UserID},
+		Role:           req.Role,
+	})
+
+	fmt.Printf("UpdateMemberRole - User %d made user %d %s of group %d\n", actor.UserID, target.UserID, req.Role, conversation.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated", "member": target})
+}
+
+// LeaveConversation - Leave a group
+func (h *ConversationHandler) LeaveConversation(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	conversation, member, ok := h.membership(c, userID.(uint))
+	if !ok {
+		return
+	}
+	if !conversation.IsGroup() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't leave a direct conversation, archive it instead"})
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		return leaveGroup(tx, conversation, member)
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to leave group"})
+		return
+	}
+
+	h.notifyMembers(conversation.ID, []uint{member.UserID}, chat.ConversationEventPayload{
+		ConversationID: conversation.ID,
+		Action:         chat.ConversationMemberLeft,
+		ActorID:        member.UserID,
+		UserIDs:        []uint{member.UserID},
+	})
+
+	fmt.Printf("LeaveConversation - User %d left group %d\n", member.UserID, conversation.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Left group"})
+}
+
+// ListMessages - Fetch a page of a conversation's messages, only from when the user joined for groups
+func (h *ConversationHandler) ListMessages(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	conversation, member, ok := h.membership(c, userID.(uint))
+	if !ok {
+		return
+	}
+
+	scope := notHiddenFrom(h.db, member.UserID).Where("conversation_id = ?", conversation.ID)
+	if conversation.IsGroup() {
+		scope = scope.Where("created_at >= ?", member.JoinedAt)
+	}
+
+	messages, hasMore, ok := messagePage(c, h.db, scope)
+	if !ok {
+		return
+	}
+
+	// Loading a direct conversation gets any undelivered messages to this user
+	var undelivered []uint
+	var partnerID uint
+	for i, message := range messages {
+		if message.ReceiverID == member.UserID && message.DeliveredAt == nil {
+			undelivered = append(undelivered, message.ID)
+			partnerID = message.SenderID
+			now := time.Now()
+			messages[i].DeliveredAt = &now
+		}
+	}
+
+	// Replies in a group don't show what was said before this member joined
+	var joinedAt time.Time
+	if conversation.IsGroup() {
+		joinedAt = member.JoinedAt
+	}
+	views, err := messageViews(h.db, messages, joinedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversation"})
+		return
+	}
+	messaging.MarkDelivered(h.db, partnerID, member.UserID, undelivered)
+
+	senderIDs := []uint{}
+	for _, message := range messages {
+		senderIDs = append(senderIDs, message.SenderID)
+	}
+
+	var senders []models.User
+	if len(senderIDs) > 0 {
+		h.db.Where("id IN ?", senderIDs).Find(&senders)
+	}
+	users := make(map[uint]models.UserSummary, len(senders))
+	for _, sender := range senders {
+		users[sender.ID] = sender.Summary()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages":             views,
+		"users":                users,
+		"has_more":             hasMore,
+		"last_read_message_id": member.LastReadMessageID,
+	})
+}
+
+// MarkConversationRead - Mark a conversation as read up to a message ID
+func (h *ConversationHandler) MarkConversationRead(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req ConversationReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+		return
+	}
+
+	conversation, member, ok := h.membership(c, userID.(uint))
+	if !ok {
+		return
+	}
+
+	var err error
+	if conversation.IsGroup() {
+		err = messaging.MarkGroupRead(h.db, member, req.UpToID, nil)
+	} else {
+		var partnerID uint
+		partnerID, err = messaging.DirectPartner(h.db, conversation.ID, member.UserID)
+		if err == nil {
+			_, err = messaging.MarkRead(h.db, member.UserID, partnerID, req.UpToID, nil)
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark conversation as read"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Conversation marked as read"})
+}
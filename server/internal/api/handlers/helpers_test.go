@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"flux/internal/auth"
+	"flux/internal/messaging"
+	"flux/internal/models"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestDB opens an empty, migrated database that is removed when the test ends
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Message{}, &models.Session{}, &models.RefreshToken{}, &models.RecoveryCode{}, &models.AuthThrottle{}, &models.LockoutEvent{}, &models.Conversation{}, &models.ConversationMember{}, &models.UserEvent{}, &models.MessageEdit{}, &models.MessageHide{}, &models.MessageReaction{}, &models.Friend{}, &models.UserIdentity{}, &models.OIDCLoginState{}, &models.PersonalAccessToken{}, &models.WSTicket{}); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// useTestKeys signs tokens with a throwaway Ed25519 key for the rest of the test
+func useTestKeys(t *testing.T) {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("encode key: %v", err)
+	}
+
+	dir := t.TempDir()
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, "test.pem"), data, 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	t.Setenv("JWT_KEYS_DIR", dir)
+	t.Setenv("JWT_ACTIVE_KEY_ID", "test")
+
+	km, err := auth.LoadKeyManagerFromEnv()
+	if err != nil {
+		t.Fatalf("load key: %v", err)
+	}
+	auth.UseKeyManager(km)
+	t.Cleanup(func() { auth.UseKeyManager(nil) })
+}
+
+// newTestUser creates a user with the given username and password
+func newTestUser(t *testing.T, db *gorm.DB, username, password string) models.User {
+	t.Helper()
+
+	user := models.User{Username: username, Email: username + "@example.com"}
+	if err := user.HashPassword(password); err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+// newTestGroup creates a group conversation with the given members
+func newTestGroup(t *testing.T, db *gorm.DB, members ...models.User) models.Conversation {
+	t.Helper()
+
+	group := models.Conversation{Kind: models.ConversationGroup, Name: "group", CreatedByID: members[0].ID}
+	if err := db.Create(&group).Error; err != nil {
+		t.Fatalf("create group: %v", err)
+	}
+	for _, user := range members {
+		member := messaging.NewMember(group.ID, user.ID, models.MemberRoleMember)
+		if err := db.Create(&member).Error; err != nil {
+			t.Fatalf("add member: %v", err)
+		}
+	}
+	return group
+}
+
+// serve calls handler with body encoded as JSON, as userID when it isn't zero,
+// and returns the recorded response
+func serve(t *testing.T, handler gin.HandlerFunc, method string, userID uint, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, "/", &buf)
+	c.Request.Header.Set("Content-Type", "application/json")
+	if userID != 0 {
+		c.Set("user_id", userID)
+	}
+
+	handler(c)
+	return w
+}
+
+// decode reads a JSON response body into a map
+func decode(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response %q: %v", w.Body.String(), err)
+	}
+	return body
+}
+
+// expectStatus fails the test unless the response has the given status
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d (body %s)", w.Code, status, w.Body.String())
+	}
+}
@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"flux/internal/auth"
+)
+
+// GetJWKS - Publish the public keys Flux tokens are signed with
+func GetJWKS(c *gin.Context) {
+	// Let verifiers cache the keys, but not for so long that rotation is slow to reach them
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": auth.JWKS()})
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"flux/internal/auth"
+)
+
+func TestGetJWKSPublishesTheSigningKeys(t *testing.T) {
+	useTestKeys(t)
+
+	w := serve(t, GetJWKS, http.MethodGet, 0, nil)
+	expectStatus(t, w, http.StatusOK)
+	if w.Header().Get("Cache-Control") == "" {
+		t.Fatal("JWKS response can't be cached")
+	}
+
+	var body struct {
+		Keys []auth.JSONWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode JWKS: %v", err)
+	}
+	if len(body.Keys) != 1 || body.Keys[0].Kid != "test" || body.Keys[0].Kty != "OKP" || body.Keys[0].X == "" {
+		t.Fatalf("JWKS = %+v, want the test key", body.Keys)
+	}
+}
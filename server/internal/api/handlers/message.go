@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 	
+	"flux/internal/messaging"
 	"flux/internal/models"
 
 	"github.com/gin-gonic/gin"
@@ -12,17 +15,115 @@ import (
 )
 
 type MessageHandler struct {
-	db *gorm.DB
+	db       *gorm.DB
+	messages *messaging.Service
 }
 
-// SendMessageRequest represents the request structure for sending a message
+// MarkReadRequest represents the body of a read receipt: every message from UserID
+// up to and including UpToID is marked as read
+type MarkReadRequest struct {
+	UserID uint `json:"user_id" binding:"required"`
+	UpToID uint `json:"up_to_id" binding:"required"`
+}
+
+// Page sizes for conversation history
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// MessageView is a message as returned in conversation history, without the sender
+// and receiver, which are sent once alongside the page
+type MessageView struct {
+	ID             uint                     `json:"ID"`
+	CreatedAt      time.Time                `json:"CreatedAt"`
+	UpdatedAt      time.Time                `json:"UpdatedAt"`
+	ConversationID uint                     `json:"conversation_id"`
+	SenderID       uint                     `json:"sender_id"`
+	ReceiverID     uint                     `json:"receiver_id"`
+	Content        string                   `json:"content"`
+	DeliveredAt    *time.Time               `json:"delivered_at"`
+	ReadAt         *time.Time               `json:"read_at"`
+	EditedAt       *time.Time               `json:"edited_at"`
+	UnsentAt       *time.Time               `json:"unsent_at"`
+	ReplyToID      *uint                    `json:"reply_to_id"`
+	ReplyTo        *models.MessageQuote     `json:"reply_to,omitempty"`
+	Reactions      []models.ReactionSummary `json:"reactions"`
+}
+
+// SendMessageRequest represents the request structure for sending a message, either
+// to a user or to a conversation the sender is in
 type SendMessageRequest struct {
-	ReceiverID uint   `json:"receiver_id" binding:"required"`
-	Content    string `json:"content" binding:"required"`
+	ReceiverID     uint   `json:"receiver_id"`
+	ConversationID uint   `json:"conversation_id"`
+	Content        string `json:"content" binding:"required"`
+	ClientMsgID    string `json:"client_msg_id" binding:"omitempty,max=64"` // retrying with the same key never sends twice
+	ReplyToID      uint   `json:"reply_to_id"`                              // message in the same conversation being answered
+}
+
+// ReactionRequest represents the emoji to add to a message
+type ReactionRequest struct {
+	Emoji string `json:"emoji" binding:"required"`
+}
+
+// EditMessageRequest represents the new content of an edited message
+type EditMessageRequest struct {
+	Content string `json:"content" binding:"required"`
 }
 
 func NewMessageHandler(db *gorm.DB) *MessageHandler {
-	return &MessageHandler{db: db}
+	return &MessageHandler{db: db, messages: messaging.NewService(db)}
+}
+
+func newMessageView(m models.Message) MessageView {
+	return MessageView{
+		ID:             m.ID,
+		CreatedAt:      m.CreatedAt,
+		UpdatedAt:      m.UpdatedAt,
+		ConversationID: m.ConversationID,
+		SenderID:       m.SenderID,
+		ReceiverID:     m.ReceiverID,
+		Content:        m.Content,
+		DeliveredAt:    m.DeliveredAt,
+		ReadAt:         m.ReadAt,
+		EditedAt:       m.EditedAt,
+		UnsentAt:       m.UnsentAt,
+		ReplyToID:      m.ReplyToID,
+		ReplyTo:        m.ReplyTo,
+		Reactions:      []models.ReactionSummary{},
+	}
+}
+
+// messageViews turns messages into views with the quotes of the messages they reply
+// to and their reactions, as seen by a viewer who joined at joinedAt (see LoadReplies)
+func messageViews(db *gorm.DB, messages []models.Message, joinedAt time.Time) ([]MessageView, error) {
+	if err := messaging.LoadReplies(db, messages, joinedAt); err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+	reactions, err := messaging.LoadReactions(db, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]MessageView, 0, len(messages))
+	for _, m := range messages {
+		view := newMessageView(m)
+		if r, ok := reactions[m.ID]; ok {
+			view.Reactions = r
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// notHiddenFrom leaves out the messages the user deleted just for themselves
+func notHiddenFrom(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Where("messages.id NOT IN (?)", db.Model(&models.MessageHide{}).Select("message_id").Where("user_id = ?", userID))
 }
 
 // SendMessage - Send a message to another user
@@ -44,40 +145,41 @@ func (h *MessageHandler) SendMessage(c *gin.Context) {
 		return
 	}
 
-	// Check if receiver exists
-	var receiver models.User
-	if err := h.db.First(&receiver, req.ReceiverID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
+	message, duplicate, err := h.messages.Send(messaging.SendRequest{
+		SenderID:       senderID.(uint),
+		ReceiverID:     req.ReceiverID,
+		ConversationID: req.ConversationID,
+		Content:        req.Content,
+		ClientMsgID:    req.ClientMsgID,
+		ReplyToID:      req.ReplyToID,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, messaging.ErrEmptyContent), errors.Is(err, messaging.ErrNoReceiver), errors.Is(err, messaging.ErrClientMsgIDTooLong), errors.Is(err, messaging.ErrInvalidReply):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, messaging.ErrReceiverNotFound):
 			c.JSON(http.StatusNotFound, gin.H{"error": "Receiver not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify receiver"})
+		case errors.Is(err, messaging.ErrNotMember):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		case errors.Is(err, messaging.ErrDuplicateMismatch):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
 		}
 		return
 	}
 
-	// Create message
-	message := models.Message{
-		SenderID:   senderID.(uint),
-		ReceiverID: req.ReceiverID,
-		Content:    req.Content,
-	}
-
-	if err := h.db.Create(&message).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+	// A retry of a message that was already sent gets the original back
+	if duplicate {
+		c.JSON(http.StatusOK, gin.H{"message": message, "duplicate": true})
 		return
 	}
 
-	// Preload sender and receiver data before returning
-	if err := h.db.Preload("Sender").Preload("Receiver").First(&message, message.ID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load message with user data"})
-		return
-	}
-
-	fmt.Printf("SendMessage - Message sent from user %d to user %d\n", message.SenderID, message.ReceiverID)
+	fmt.Printf("SendMessage - Message sent from user %d to conversation %d\n", message.SenderID, message.ConversationID)
 	c.JSON(http.StatusCreated, gin.H{"message": message})
 }
 
-// GetConversation - Fetch conversation between authenticated user and another user
+// GetConversation - Fetch a page of the conversation between the authenticated user and another user
 func (h *MessageHandler) GetConversation(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -110,15 +212,312 @@ func (h *MessageHandler) GetConversation(c *gin.Context) {
 		return
 	}
 
-	var messages []models.Message
-	if err := h.db.Preload("Sender").Preload("Receiver").Where(
+	var me models.User
+	if err := h.db.First(&me, userID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify user"})
+		return
+	}
+
+	pair := notHiddenFrom(h.db, me.ID).Where(
 		"(sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)",
-		userID, uint(otherUserID), uint(otherUserID), userID,
-	).Order("created_at asc").Find(&messages).Error; err != nil {
+		me.ID, otherUser.ID, otherUser.ID, me.ID,
+	)
+	messages, hasMore, ok := messagePage(c, h.db, pair)
+	if !ok {
+		return
+	}
+
+	// Loading the conversation gets any undelivered messages to this user
+	var undelivered []uint
+	for i, message := range messages {
+		if message.ReceiverID == me.ID && message.DeliveredAt == nil {
+			undelivered = append(undelivered, message.ID)
+			now := time.Now()
+			messages[i].DeliveredAt = &now
+		}
+	}
+	views, err := messageViews(h.db, messages, time.Time{})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversation"})
 		return
 	}
+	messaging.MarkDelivered(h.db, otherUser.ID, me.ID, undelivered)
+
+	// The newest message of each side that the other has read
+	var lastReadByMe, lastReadByThem uint
+	h.db.Model(&models.Message{}).Where("sender_id = ? AND receiver_id = ? AND read_at IS NOT NULL", otherUser.ID, me.ID).
+		Select("COALESCE(MAX(id), 0)").Scan(&lastReadByMe)
+	h.db.Model(&models.Message{}).Where("sender_id = ? AND receiver_id = ? AND read_at IS NOT NULL", me.ID, otherUser.ID).
+		Select("COALESCE(MAX(id), 0)").Scan(&lastReadByThem)
+
+	fmt.Printf("GetConversation - Found %d messages between users %d and %d\n", len(views), me.ID, otherUser.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"messages": views,
+		"users": map[uint]models.UserSummary{
+			me.ID:        me.Summary(),
+			otherUser.ID: otherUser.Summary(),
+		},
+		"has_more":          hasMore,
+		"last_read_by_me":   lastReadByMe,
+		"last_read_by_them": lastReadByThem,
+	})
+}
+
+// messagePage loads one page of the messages matched by scope, using the before,
+// after and limit query parameters. Cursors are message IDs, and pages are walked in
+// (created_at, id) order to match the index. It answers the request itself and
+// returns false if the parameters are bad.
+func messagePage(c *gin.Context, db *gorm.DB, scope *gorm.DB) ([]models.Message, bool, bool) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if limit < 1 || limit > maxPageSize {
+		limit = defaultPageSize
+	}
+
+	before, after := c.Query("before"), c.Query("after")
+	if before != "" && after != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use either before or after, not both"})
+		return nil, false, false
+	}
 
-	fmt.Printf("GetConversation - Found %d messages between users %v and %d\n", len(messages), userID, uint(otherUserID))
-	c.JSON(http.StatusOK, gin.H{"messages": messages})
-}
\ No newline at end of file
+	query := db.Model(&models.Message{}).Where(scope)
+	newestFirst := after == ""
+	if cursorID := before + after; cursorID != "" {
+		var cursor models.Message
+		if err := db.Where(scope).Where("id = ?", cursorID).First(&cursor).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cursor is not a message in this conversation"})
+			return nil, false, false
+		}
+
+		if newestFirst {
+			query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+		} else {
+			query = query.Where("created_at > ? OR (created_at = ? AND id > ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+		}
+	}
+
+	if newestFirst {
+		query = query.Order("created_at desc, id desc")
+	} else {
+		query = query.Order("created_at asc, id asc")
+	}
+
+	// Ask for one extra row to find out whether there is another page
+	var messages []models.Message
+	if err := query.Limit(limit + 1).Find(&messages).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversation"})
+		return nil, false, false
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	// Pages always come back oldest first
+	if newestFirst {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages, hasMore, true
+}
+
+// MarkRead - Mark messages from another user as read, up to a message ID
+func (h *MessageHandler) MarkRead(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req MarkReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+		return
+	}
+
+	updated, err := messaging.MarkRead(h.db, userID.(uint), req.UserID, req.UpToID, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark messages as read"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Messages marked as read", "updated": updated})
+}
+
+// messageChangeError reports why editing or deleting a message failed
+func messageChangeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, messaging.ErrMessageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+	case errors.Is(err, messaging.ErrNotSender):
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only change your own messages"})
+	case errors.Is(err, messaging.ErrEditWindowClosed):
+		c.JSON(http.StatusForbidden, gin.H{"error": "This message can no longer be edited"})
+	case errors.Is(err, messaging.ErrMessageUnsent):
+		c.JSON(http.StatusConflict, gin.H{"error": "This message was deleted"})
+	case errors.Is(err, messaging.ErrEmptyContent):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Message content can't be empty"})
+	case errors.Is(err, messaging.ErrInvalidEmoji):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reactions must be a single emoji"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update message"})
+	}
+}
+
+// EditMessage - Change the content of a message the authenticated user sent, within the edit window
+func (h *MessageHandler) EditMessage(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	var req EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+		return
+	}
+
+	message, err := h.messages.Edit(userID.(uint), uint(messageID), req.Content)
+	if err != nil {
+		messageChangeError(c, err)
+		return
+	}
+
+	views, err := messageViews(h.db, []models.Message{message}, time.Time{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load message"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": views[0]})
+}
+
+// DeleteMessage - Delete a message the authenticated user sent, for themselves or with ?for=everyone for everyone
+func (h *MessageHandler) DeleteMessage(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	scope := c.DefaultQuery("for", "me")
+	if scope != "me" && scope != "everyone" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "for must be me or everyone"})
+		return
+	}
+
+	if err := h.messages.Delete(userID.(uint), uint(messageID), scope == "everyone"); err != nil {
+		messageChangeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Message deleted", "for": scope})
+}
+
+// GetMessageHistory - List the earlier versions of an edited message
+func (h *MessageHandler) GetMessageHistory(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	edits, err := h.messages.History(userID.(uint), uint(messageID))
+	if err != nil {
+		messageChangeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"edits": edits})
+}
+
+// AddReaction - React to a message in one of the user's conversations with an emoji
+func (h *MessageHandler) AddReaction(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	var req ReactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+		return
+	}
+
+	added, err := h.messages.React(userID.(uint), uint(messageID), req.Emoji, true)
+	if err != nil {
+		messageChangeError(c, err)
+		return
+	}
+
+	status := http.StatusOK
+	if added {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{"message": "Reaction added"})
+}
+
+// RemoveReaction - Take back the user's emoji reaction on a message
+func (h *MessageHandler) RemoveReaction(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	removed, err := h.messages.React(userID.(uint), uint(messageID), c.Param("emoji"), false)
+	if err != nil {
+		messageChangeError(c, err)
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reaction not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Reaction removed"})
+}
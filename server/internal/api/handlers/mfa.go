@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"flux/internal/auth"
+	"flux/internal/models"
+)
+
+const recoveryCodeCount = 10
+
+var errInvalidSecondFactor = errors.New("invalid two-factor code")
+
+// MFACodeRequest carries either a TOTP code or a recovery code
+type MFACodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// MFAVerifyRequest represents the second step of a login with 2FA enabled
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	DeviceID string `json:"device_id"`
+	MFACodeRequest
+}
+
+// DisableTOTPRequest requires the password as well as a second factor
+type DisableTOTPRequest struct {
+	Password string `json:"password" binding:"required"`
+	MFACodeRequest
+}
+
+// totpIssuer is the name authenticator apps show next to the account
+func totpIssuer() string {
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "Flux"
+	}
+	return issuer
+}
+
+// verifySecondFactor checks a TOTP or recovery code for the user and consumes it so it can't be reused
+func verifySecondFactor(tx *gorm.DB, user models.User, req MFACodeRequest) error {
+	if req.Code != "" {
+		step, ok := auth.ValidateTOTP(user.TOTPSecret, req.Code, time.Now())
+		if !ok {
+			return errInvalidSecondFactor
+		}
+
+		// Only accept a time step newer than the last one used
+		result := tx.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			UpdateColumn("totp_last_step", step)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvalidSecondFactor
+		}
+		return nil
+	}
+
+	if req.RecoveryCode != "" {
+		hash := auth.HashToken(auth.NormalizeRecoveryCode(req.RecoveryCode))
+		result := tx.Model(&models.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hash).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvalidSecondFactor
+		}
+		return nil
+	}
+
+	return errInvalidSecondFactor
+}
+
+// replaceRecoveryCodes discards the user's recovery codes and returns a fresh set
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		if err := tx.Create(&models.RecoveryCode{
+			UserID:   userID,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code)),
+		}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// currentUser loads the authenticated user from the database
+func currentUser(c *gin.Context, db *gorm.DB) (models.User, bool) {
+	var user models.User
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return user, false
+	}
+
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return user, false
+	}
+
+	return user, true
+}
+
+// SetupTOTP - Generate a new TOTP secret for the user to add to their authenticator app
+func (h *AuthHandler) SetupTOTP(c *gin.Context) {
+	user, ok := currentUser(c, h.db)
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+
+	// The secret stays inactive until the user proves they can generate codes from it
+	if err := h.db.Model(&user).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": auth.TOTPURI(totpIssuer(), user.Username, secret),
+	})
+}
+
+// EnableTOTP - Confirm the first code from the authenticator app and turn on 2FA
+func (h *AuthHandler) EnableTOTP(c *gin.Context) {
+	user, ok := currentUser(c, h.db)
+	if !ok {
+		return
+	}
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Call /auth/mfa/totp/setup first"})
+		return
+	}
+
+	// Counted with the login's second step, so a stolen session can't guess codes here instead
+	guardKey := auth.MFAUserKey(user.ID)
+	if wait, blocked := h.loginGuard.Check(guardKey); blocked {
+		tooManyAttempts(c, wait)
+		return
+	}
+
+	var codes []string
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := verifySecondFactor(tx, user, MFACodeRequest{Code: req.Code}); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&user).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err == errInvalidSecondFactor {
+		h.loginGuard.Failure(user.Username, c.ClientIP(), guardKey)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	h.loginGuard.Success(guardKey)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTOTP - Turn off 2FA after checking the password and a second factor
+func (h *AuthHandler) DisableTOTP(c *gin.Context) {
+	user, ok := currentUser(c, h.db)
+	if !ok {
+		return
+	}
+
+	var req DisableTOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	if !checkCurrentPassword(c, h.loginGuard, user, req.Password) {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := verifySecondFactor(tx, user, req.MFACodeRequest); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_enabled":   false,
+			"totp_secret":    "",
+			"totp_last_step": 0,
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err == errInvalidSecondFactor {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes - Replace the user's recovery codes after checking a TOTP code
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	user, ok := currentUser(c, h.db)
+	if !ok {
+		return
+	}
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	guardKey := auth.MFAUserKey(user.ID)
+	if wait, blocked := h.loginGuard.Check(guardKey); blocked {
+		tooManyAttempts(c, wait)
+		return
+	}
+
+	var codes []string
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := verifySecondFactor(tx, user, MFACodeRequest{Code: req.Code}); err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err == errInvalidSecondFactor {
+		h.loginGuard.Failure(user.Username, c.ClientIP(), guardKey)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to regenerate recovery codes"})
+		return
+	}
+
+	h.loginGuard.Success(guardKey)
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// VerifyMFA - Exchange an mfa_pending token and a second factor for a full session
+func (h *AuthHandler) VerifyMFA(c *gin.Context) {
+	var req MFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, err := auth.ParseMFAToken(req.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, claims.UserID).Error; err != nil || !user.TOTPEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	// Six-digit codes are easy to guess without a limit
+	guardKey := auth.MFAUserKey(user.ID)
+	if wait, blocked := h.loginGuard.Check(guardKey); blocked {
+		tooManyAttempts(c, wait)
+		return
+	}
+
+	if err := verifySecondFactor(h.db, user, req.MFACodeRequest); err != nil {
+		if err != errInvalidSecondFactor {
+			fmt.Printf("VerifyMFA - Failed to check second factor for user %d: %v\n", user.ID, err)
+		}
+		h.loginGuard.Failure(user.Username, c.ClientIP(), guardKey)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	h.loginGuard.Success(guardKey)
+
+	if loginBlocked(c, user) {
+		return
+	}
+
+	response, err := h.startSession(c, user, req.DeviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	response["message"] = "Login successful"
+	response["user"] = gin.H{
+		"id":             user.ID,
+		"username":       user.Username,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"flux/internal/auth"
+	"flux/internal/models"
+)
+
+// totpAt computes the code an authenticator app shows for the secret at a time step
+func totpAt(t *testing.T, secret string, step int64) string {
+	t.Helper()
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// newTOTPUser creates a user with 2FA enabled and returns them with their secret
+func newTOTPUser(t *testing.T, h *AuthHandler) (models.User, string) {
+	t.Helper()
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("generate secret: %v", err)
+	}
+
+	user := newTestUser(t, h.db, "alice", "password123")
+	if err := h.db.Model(&user).Updates(map[string]interface{}{"totp_secret": secret, "totp_enabled": true}).Error; err != nil {
+		t.Fatalf("enable totp: %v", err)
+	}
+	h.db.First(&user, user.ID)
+	return user, secret
+}
+
+func TestTOTPCodeCannotBeReplayed(t *testing.T) {
+	h := newTestAuthHandler(t)
+	user, secret := newTOTPUser(t, h)
+	step := time.Now().Unix() / 30
+
+	code := totpAt(t, secret, step)
+	if err := verifySecondFactor(h.db, user, MFACodeRequest{Code: code}); err != nil {
+		t.Fatalf("first use of code: %v", err)
+	}
+
+	// The same code, and any code from an earlier step, is refused from now on
+	if err := verifySecondFactor(h.db, user, MFACodeRequest{Code: code}); err != errInvalidSecondFactor {
+		t.Fatalf("replayed code: err = %v, want %v", err, errInvalidSecondFactor)
+	}
+	if err := verifySecondFactor(h.db, user, MFACodeRequest{Code: totpAt(t, secret, step-1)}); err != errInvalidSecondFactor {
+		t.Fatalf("code from an earlier step: err = %v, want %v", err, errInvalidSecondFactor)
+	}
+
+	// A code from a later step is still accepted within the allowed skew
+	if err := verifySecondFactor(h.db, user, MFACodeRequest{Code: totpAt(t, secret, step+1)}); err != nil {
+		t.Fatalf("code from the next step: %v", err)
+	}
+}
+
+func TestRecoveryCodeIsSingleUse(t *testing.T) {
+	h := newTestAuthHandler(t)
+	user, _ := newTOTPUser(t, h)
+
+	codes, err := replaceRecoveryCodes(h.db, user.ID)
+	if err != nil {
+		t.Fatalf("create recovery codes: %v", err)
+	}
+
+	if err := verifySecondFactor(h.db, user, MFACodeRequest{RecoveryCode: codes[0]}); err != nil {
+		t.Fatalf("first use of recovery code: %v", err)
+	}
+	if err := verifySecondFactor(h.db, user, MFACodeRequest{RecoveryCode: codes[0]}); err != errInvalidSecondFactor {
+		t.Fatalf("reused recovery code: err = %v, want %v", err, errInvalidSecondFactor)
+	}
+}
+
+func TestDisableTOTPThrottlesPasswordGuesses(t *testing.T) {
+	h := newTestAuthHandler(t)
+	user, secret := newTOTPUser(t, h)
+	code := totpAt(t, secret, time.Now().Unix()/30)
+
+	for i := 0; i < auth.DefaultGuardConfig.BackoffAfter+1; i++ {
+		w := serve(t, h.DisableTOTP, http.MethodPost, user.ID, DisableTOTPRequest{Password: "wrong", MFACodeRequest: MFACodeRequest{Code: code}})
+		expectStatus(t, w, http.StatusUnauthorized)
+	}
+
+	// Even the right password is refused while backing off
+	w := serve(t, h.DisableTOTP, http.MethodPost, user.ID, DisableTOTPRequest{Password: "password123", MFACodeRequest: MFACodeRequest{Code: code}})
+	expectStatus(t, w, http.StatusTooManyRequests)
+
+	h.db.First(&user, user.ID)
+	if !user.TOTPEnabled {
+		t.Fatal("two-factor authentication was disabled")
+	}
+}
+
+// wrongCode returns a code that isn't valid for the secret right now
+func wrongCode(t *testing.T, secret string) string {
+	t.Helper()
+
+	step := time.Now().Unix() / 30
+	for n := 0; ; n++ {
+		code := fmt.Sprintf("%06d", n)
+		if code != totpAt(t, secret, step-1) && code != totpAt(t, secret, step) && code != totpAt(t, secret, step+1) {
+			return code
+		}
+	}
+}
+
+func TestEnableTOTPThrottlesCodeGuesses(t *testing.T) {
+	h := newTestAuthHandler(t)
+	user, secret := newTOTPUser(t, h)
+	h.db.Model(&user).Update("totp_enabled", false)
+
+	for i := 0; i < auth.DefaultGuardConfig.BackoffAfter+1; i++ {
+		w := serve(t, h.EnableTOTP, http.MethodPost, user.ID, MFACodeRequest{Code: wrongCode(t, secret)})
+		expectStatus(t, w, http.StatusBadRequest)
+	}
+
+	w := serve(t, h.EnableTOTP, http.MethodPost, user.ID, MFACodeRequest{Code: totpAt(t, secret, time.Now().Unix()/30)})
+	expectStatus(t, w, http.StatusTooManyRequests)
+}
+
+func TestRegenerateRecoveryCodesThrottlesCodeGuesses(t *testing.T) {
+	h := newTestAuthHandler(t)
+	user, secret := newTOTPUser(t, h)
+
+	for i := 0; i < auth.DefaultGuardConfig.BackoffAfter+1; i++ {
+		w := serve(t, h.RegenerateRecoveryCodes, http.MethodPost, user.ID, MFACodeRequest{Code: wrongCode(t, secret)})
+		expectStatus(t, w, http.StatusUnauthorized)
+	}
+
+	w := serve(t, h.RegenerateRecoveryCodes, http.MethodPost, user.ID, MFACodeRequest{Code: totpAt(t, secret, time.Now().Unix()/30)})
+	expectStatus(t, w, http.StatusTooManyRequests)
+}
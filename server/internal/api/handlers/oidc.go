@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"flux/internal/auth"
+	"flux/internal/models"
+	"flux/internal/oidc"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+// oidcStateCookie holds the state of the login this browser started. The callback
+// must come with it, so a login someone else started can't be finished here.
+const oidcStateCookie = "flux_oidc_state"
+
+var (
+	errOIDCNoEmail      = errors.New("provider did not return an email address")
+	errOIDCLinkConflict = errors.New("an unverified account already uses this email")
+)
+
+// OIDCCallbackRequest carries the code and state the provider redirected back with
+type OIDCCallbackRequest struct {
+	Code     string `json:"code" binding:"required"`
+	State    string `json:"state" binding:"required"`
+	DeviceID string `json:"device_id"`
+}
+
+// oidcProvider looks up the provider named in the route, responding with 404 if it isn't configured
+func (h *AuthHandler) oidcProvider(c *gin.Context) (*oidc.Provider, bool) {
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+		return nil, false
+	}
+	return provider, true
+}
+
+// ListOIDCProviders - List the external login providers that are configured
+func (h *AuthHandler) ListOIDCProviders(c *gin.Context) {
+	names := make([]string, 0, len(h.providers))
+	for name := range h.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	c.JSON(http.StatusOK, gin.H{"providers": names})
+}
+
+// OIDCLogin - Start an authorization code + PKCE login with a provider
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	provider, ok := h.oidcProvider(c)
+	if !ok {
+		return
+	}
+
+	state, stateHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	nonce, err := oidc.GenerateNonce()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	verifier, err := oidc.GenerateVerifier()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), state, nonce, verifier)
+	if err != nil {
+		fmt.Printf("OIDCLogin - %v\n", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Login provider is unavailable"})
+		return
+	}
+
+	if err := h.db.Create(&models.OIDCLoginState{
+		StateHash:    stateHash,
+		Provider:     provider.Name(),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(oidcStateTTL.Seconds()), "/auth/oidc", "", c.Request.TLS != nil, true)
+
+	c.JSON(http.StatusOK, gin.H{
+		"authorization_url": authURL,
+		"state":             state,
+	})
+}
+
+// OIDCCallback - Finish a provider login and sign the user in, linking or creating the account
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	provider, ok := h.oidcProvider(c)
+	if !ok {
+		return
+	}
+
+	var req OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The state must be the one this browser was given when it started the login
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie), []byte(req.State)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login state"})
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, "/auth/oidc", "", c.Request.TLS != nil, true)
+
+	// The state is single-use: load and delete it in one go
+	var loginState models.OIDCLoginState
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("state_hash = ? AND provider = ?", auth.HashToken(req.State), provider.Name()).
+			First(&loginState).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&loginState).Error
+	})
+	if err != nil || time.Now().After(loginState.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login state"})
+		return
+	}
+
+	claims, err := provider.Exchange(c.Request.Context(), req.Code, loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		fmt.Printf("OIDCCallback - %s: %v\n", provider.Name(), err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login with provider failed"})
+		return
+	}
+
+	user, err := h.resolveOIDCUser(provider.Name(), claims)
+	switch err {
+	case nil:
+	case errOIDCNoEmail:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The provider did not share an email address"})
+		return
+	case errOIDCLinkConflict:
+		c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists. Log in with your password and verify your email to link it"})
+		return
+	default:
+		fmt.Printf("OIDCCallback - Failed to resolve user: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+
+	h.completeLogin(c, user, req.DeviceID)
+}
+
+// resolveOIDCUser finds the user behind an external identity, linking it to an
+// existing account by verified email or creating a new account
+func (h *AuthHandler) resolveOIDCUser(provider string, claims *oidc.IDTokenClaims) (models.User, error) {
+	var user models.User
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var identity models.UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", provider, claims.Subject).First(&identity).Error
+		if err == nil {
+			return tx.First(&user, identity.UserID).Error
+		}
+		if err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		if claims.Email == "" {
+			return errOIDCNoEmail
+		}
+
+		err = tx.Where("email = ?", claims.Email).First(&user).Error
+		switch {
+		case err == nil:
+			// Only link when both sides have proven ownership of the address,
+			// otherwise someone could pre-register a victim's email and take over their login
+			if !claims.EmailVerified || !user.EmailVerified {
+				return errOIDCLinkConflict
+			}
+		case err == gorm.ErrRecordNotFound:
+			user, err = createOIDCUser(tx, claims)
+			if err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		return tx.Create(&models.UserIdentity{
+			UserID:   user.ID,
+			Provider: provider,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		}).Error
+	})
+
+	return user, err
+}
+
+// createOIDCUser creates an account for a first-time external login. The account
+// gets a random password, so it can only be used through the provider until the
+// user sets one with a password reset.
+func createOIDCUser(tx *gorm.DB, claims *oidc.IDTokenClaims) (models.User, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	base = sanitizeUsername(base)
+
+	username := base
+	for i := 2; ; i++ {
+		var count int64
+		if err := tx.Model(&models.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+			return models.User{}, err
+		}
+		if count == 0 {
+			break
+		}
+		username = fmt.Sprintf("%s%d", base, i)
+	}
+
+	password, _, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return models.User{}, err
+	}
+
+	user := models.User{
+		Username:      username,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	}
+	if err := user.HashPassword(password); err != nil {
+		return models.User{}, err
+	}
+
+	if err := tx.Create(&user).Error; err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// sanitizeUsername keeps a provider-supplied name within the rules Register enforces
+func sanitizeUsername(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '.' || r == '-' {
+			b.WriteRune(r)
+		}
+	}
+
+	username := b.String()
+	if len(username) > 40 {
+		username = username[:40]
+	}
+	for len(username) < 3 {
+		username += "_"
+	}
+	return username
+}
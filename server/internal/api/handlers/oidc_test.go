@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"flux/internal/models"
+	"flux/internal/oidc"
+	"flux/internal/oidc/oidctest"
+)
+
+// oidcTest is an auth handler with one provider, "mock", served by a mock provider
+type oidcTest struct {
+	h      *AuthHandler
+	mock   *oidctest.Provider
+	router *gin.Engine
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+
+	mock, err := oidctest.New("")
+	if err != nil {
+		t.Fatalf("start mock provider: %v", err)
+	}
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+	mock.Issuer = server.URL
+
+	h := newTestAuthHandler(t)
+	h.providers = map[string]*oidc.Provider{
+		"mock": oidc.NewProvider(oidc.Config{
+			Name:        "mock",
+			Issuer:      server.URL,
+			ClientID:    "flux",
+			RedirectURL: "http://localhost:5173/oauth/callback",
+		}),
+	}
+
+	router := gin.New()
+	router.GET("/auth/oidc/:provider/login", h.OIDCLogin)
+	router.POST("/auth/oidc/:provider/callback", h.OIDCCallback)
+	return &oidcTest{h: h, mock: mock, router: router}
+}
+
+// start begins a login and returns the state cookie the browser was given and the
+// code and state the provider redirects back with for the identity
+func (o *oidcTest) start(t *testing.T, identity oidctest.Identity) (*http.Cookie, string, string) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/oidc/mock/login", nil))
+	expectStatus(t, w, http.StatusOK)
+
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatalf("login didn't set an HttpOnly state cookie: %v", w.Result().Cookies())
+	}
+
+	code, state, err := o.mock.Authorize(decode(t, w)["authorization_url"].(string), identity)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	return cookie, code, state
+}
+
+// callback finishes a login, sending the cookie if there is one
+func (o *oidcTest) callback(t *testing.T, cookie *http.Cookie, code, state string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, _ := json.Marshal(OIDCCallbackRequest{Code: code, State: state})
+	req := httptest.NewRequest(http.MethodPost, "/auth/oidc/mock/callback", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	o.router.ServeHTTP(w, req)
+	return w
+}
+
+// loggedInAs returns the ID of the user a successful callback signed in
+func loggedInAs(t *testing.T, w *httptest.ResponseRecorder) uint {
+	t.Helper()
+
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	if body["token"] == nil {
+		t.Fatalf("callback didn't return a token: %v", body)
+	}
+	return uint(body["user"].(map[string]interface{})["id"].(float64))
+}
+
+var oidcAlice = oidctest.Identity{Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true, Username: "alice"}
+
+func TestOIDCLoginCreatesAccount(t *testing.T) {
+	o := newOIDCTest(t)
+
+	cookie, code, state := o.start(t, oidcAlice)
+	id := loggedInAs(t, o.callback(t, cookie, code, state))
+
+	var user models.User
+	o.h.db.First(&user, id)
+	if user.Email != oidcAlice.Email || !user.EmailVerified || user.Username != "alice" {
+		t.Fatalf("created user %+v, want alice with a verified email", user)
+	}
+
+	// The next login with the same identity signs into the same account
+	cookie, code, state = o.start(t, oidcAlice)
+	if again := loggedInAs(t, o.callback(t, cookie, code, state)); again != id {
+		t.Fatalf("second login signed into user %d, want %d", again, id)
+	}
+}
+
+func TestOIDCStateIsSingleUse(t *testing.T) {
+	o := newOIDCTest(t)
+
+	cookie, code, state := o.start(t, oidcAlice)
+	loggedInAs(t, o.callback(t, cookie, code, state))
+
+	w := o.callback(t, cookie, code, state)
+	expectStatus(t, w, http.StatusBadRequest)
+}
+
+func TestOIDCCallbackNeedsTheBrowserThatStartedIt(t *testing.T) {
+	o := newOIDCTest(t)
+
+	// An attacker starts a login and gets a victim's browser to finish it
+	attackerCookie, code, state := o.start(t, oidctest.Identity{Subject: "mallory-sub", Email: "mallory@example.com", EmailVerified: true})
+	victimCookie, _, _ := o.start(t, oidcAlice)
+
+	expectStatus(t, o.callback(t, nil, code, state), http.StatusBadRequest)
+	expectStatus(t, o.callback(t, victimCookie, code, state), http.StatusBadRequest)
+
+	// The browser that started it can still finish it
+	loggedInAs(t, o.callback(t, attackerCookie, code, state))
+}
+
+func TestOIDCCallbackRejectsInvalidIDToken(t *testing.T) {
+	o := newOIDCTest(t)
+	o.mock.Tamper = func(claims jwt.MapClaims) { claims["aud"] = "someone-else" }
+
+	cookie, code, state := o.start(t, oidcAlice)
+	expectStatus(t, o.callback(t, cookie, code, state), http.StatusUnauthorized)
+
+	var count int64
+	o.h.db.Model(&models.User{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d accounts were created from a rejected ID token", count)
+	}
+}
+
+func TestOIDCLinksOnlyVerifiedEmail(t *testing.T) {
+	tests := []struct {
+		name          string
+		localVerified bool
+		idpVerified   bool
+		linked        bool
+	}{
+		{"both verified", true, true, true},
+		{"provider didn't verify", true, false, false},
+		{"account didn't verify", false, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOIDCTest(t)
+			existing := newTestUser(t, o.h.db, "alice", "password123")
+			o.h.db.Model(&existing).Update("email_verified", tt.localVerified)
+
+			identity := oidcAlice
+			identity.EmailVerified = tt.idpVerified
+			cookie, code, state := o.start(t, identity)
+			w := o.callback(t, cookie, code, state)
+
+			if !tt.linked {
+				expectStatus(t, w, http.StatusConflict)
+				var identities int64
+				o.h.db.Model(&models.UserIdentity{}).Count(&identities)
+				if identities != 0 {
+					t.Fatal("identity was linked anyway")
+				}
+				return
+			}
+			if id := loggedInAs(t, w); id != existing.ID {
+				t.Fatalf("signed into user %d, want the existing account %d", id, existing.ID)
+			}
+		})
+	}
+}
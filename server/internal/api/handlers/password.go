@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"flux/internal/auth"
+	"flux/internal/mail"
+	"flux/internal/models"
+)
+
+const (
+	passwordResetTokenTTL = time.Hour
+	emailVerifyTokenTTL   = 48 * time.Hour
+)
+
+var errInvalidUserToken = errors.New("invalid or expired token")
+
+// ForgotPasswordRequest represents the body of a password reset request
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest represents the body used to set a new password with a reset token
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+// VerifyEmailRequest represents the body used to confirm an email address
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// appURL builds a link into the frontend, which is where emailed tokens are handled
+func appURL(path, token string) string {
+	base := os.Getenv("APP_BASE_URL")
+	if base == "" {
+		base = "http://localhost:5173"
+	}
+	return fmt.Sprintf("%s%s?token=%s", base, path, url.QueryEscape(token))
+}
+
+// issueUserToken creates a single-use token for the user, invalidating older ones with the same purpose
+func issueUserToken(db *gorm.DB, userID uint, purpose string, ttl time.Duration) (string, error) {
+	raw, hash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.UserToken{
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return raw, nil
+}
+
+// consumeUserToken marks a token as used and returns it, failing if it was already used or has expired
+func consumeUserToken(tx *gorm.DB, raw, purpose string) (models.UserToken, error) {
+	var token models.UserToken
+	if err := tx.Where("token_hash = ? AND purpose = ?", auth.HashToken(raw), purpose).First(&token).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return token, errInvalidUserToken
+		}
+		return token, err
+	}
+
+	if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+		return token, errInvalidUserToken
+	}
+
+	// Guard against the same token being redeemed twice concurrently
+	result := tx.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL", token.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return token, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return token, errInvalidUserToken
+	}
+
+	return token, nil
+}
+
+// sendVerificationEmail emails the user a link to confirm their address
+func sendVerificationEmail(db *gorm.DB, mailer mail.Mailer, user models.User) error {
+	token, err := issueUserToken(db, user.ID, models.TokenPurposeEmailVerify, emailVerifyTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(
+		"Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s\n\nThe link expires in %d hours.\n",
+		user.Username, appURL("/verify-email", token), int(emailVerifyTokenTTL.Hours()),
+	)
+	return mailer.Send(user.Email, "Confirm your Flux email address", body)
+}
+
+// ForgotPassword - Email a password reset link if the address belongs to an account
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Always answer the same way so the endpoint can't be used to discover accounts
+	response := gin.H{"message": "If an account exists for that email, a reset link has been sent"}
+
+	var user models.User
+	if err := h.db.Where("email = ?", req.Email).First(&user).Error; err != nil {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	token, err := issueUserToken(h.db, user.ID, models.TokenPurposePasswordReset, passwordResetTokenTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reset token"})
+		return
+	}
+
+	body := fmt.Sprintf(
+		"Hi %s,\n\nSomeone asked to reset the password for your Flux account. If that was you, open the link below:\n\n%s\n\nThe link expires in %d minutes. If you did not ask for this, you can ignore this email.\n",
+		user.Username, appURL("/reset-password", token), int(passwordResetTokenTTL.Minutes()),
+	)
+	if err := h.mailer.Send(user.Email, "Reset your Flux password", body); err != nil {
+		fmt.Printf("ForgotPassword - Failed to send reset email to user %d: %v\n", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ResetPassword - Set a new password using a reset token and log out every session
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		token, err := consumeUserToken(tx, req.Token, models.TokenPurposePasswordReset)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.First(&user, token.UserID).Error; err != nil {
+			return err
+		}
+
+		if err := user.HashPassword(req.Password); err != nil {
+			return err
+		}
+
+		// Following a link from the inbox proves ownership of the address too
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"password_hash":       user.PasswordHash,
+			"email_verified":      true,
+			"must_reset_password": false,
+		}).Error
+	})
+	if err == errInvalidUserToken {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	if err := revokeUserSessions(h.db, user.ID, ""); err != nil {
+		fmt.Printf("ResetPassword - Failed to revoke sessions for user %d: %v\n", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset, please log in again"})
+}
+
+// VerifyEmail - Confirm the user's email address using a verification token
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		token, err := consumeUserToken(tx, req.Token, models.TokenPurposeEmailVerify)
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&models.User{}).Where("id = ?", token.UserID).Update("email_verified", true).Error
+	})
+	if err == errInvalidUserToken {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
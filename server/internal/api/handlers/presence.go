@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"flux/internal/chat"
+	"flux/internal/models"
+)
+
+// maxPresenceIDs caps how many users one presence lookup can ask about
+const maxPresenceIDs = 100
+
+type PresenceHandler struct {
+	db *gorm.DB
+}
+
+func NewPresenceHandler(db *gorm.DB) *PresenceHandler {
+	return &PresenceHandler{db: db}
+}
+
+// mutualFollowers returns the users who follow userID and are followed back by them
+func mutualFollowers(db *gorm.DB, userID uint) ([]uint, error) {
+	var ids []uint
+	err := db.Model(&models.Friend{}).
+		Where("following_id = ? AND follower_id IN (?)", userID,
+			db.Model(&models.Friend{}).Select("following_id").Where("follower_id = ?", userID)).
+		Pluck("follower_id", &ids).Error
+	return ids, err
+}
+
+// publishPresence records when a user went offline and pushes their new status to
+// their mutual followers
+func publishPresence(db *gorm.DB, userID uint, status string) {
+	payload := chat.PresencePayload{UserID: userID, Status: status}
+
+	if status == chat.StatusOffline {
+		now := time.Now()
+		if err := db.Model(&models.User{}).Where("id = ?", userID).Update("last_seen_at", now).Error; err != nil {
+			fmt.Printf("Failed to record last seen for user %d: %v\n", userID, err)
+		}
+
+		var user models.User
+		if err := db.Select("id", "hide_last_seen").First(&user, userID).Error; err == nil && !user.HideLastSeen {
+			payload.LastSeen = &now
+		}
+	}
+
+	followers, err := mutualFollowers(db, userID)
+	if err != nil {
+		fmt.Printf("Failed to load mutual followers of user %d: %v\n", userID, err)
+		return
+	}
+
+	env := chat.NewEnvelope(chat.EventPresence, "", payload)
+	for _, followerID := range followers {
+		chat.DefaultHub.SendToUser(followerID, env)
+	}
+	fmt.Printf("User %d is now %s, told %d mutual follower(s)\n", userID, status, len(followers))
+}
+
+// GetPresence - Get the online status and last seen time of up to 100 users. Like
+// the presence events, only the caller's own and their mutual followers' are shown.
+func (h *PresenceHandler) GetPresence(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var ids []uint
+	seen := map[uint]bool{}
+	for _, raw := range strings.Split(c.Query("ids"), ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil || id == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID: " + raw})
+			return
+		}
+		if !seen[uint(id)] {
+			seen[uint(id)] = true
+			ids = append(ids, uint(id))
+		}
+	}
+
+	if len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids is required"})
+		return
+	}
+	if len(ids) > maxPresenceIDs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d ids can be looked up at once", maxPresenceIDs)})
+		return
+	}
+
+	followers, err := mutualFollowers(h.db, userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch presence"})
+		return
+	}
+	visible := append(followers, userID.(uint))
+
+	var users []models.User
+	if err := h.db.Select("id", "hide_last_seen", "last_seen_at").Where("id IN ? AND id IN ?", ids, visible).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch presence"})
+		return
+	}
+
+	byID := make(map[uint]models.User, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+
+	// Keep the order the IDs were asked for in, leaving out users that don't exist
+	// and those whose presence the caller can't see
+	presence := make([]chat.PresencePayload, 0, len(users))
+	for _, id := range ids {
+		user, ok := byID[id]
+		if !ok {
+			continue
+		}
+
+		entry := chat.PresencePayload{UserID: id, Status: chat.DefaultHub.Status(id)}
+		if entry.Status == chat.StatusOffline && (!user.HideLastSeen || id == userID.(uint)) {
+			entry.LastSeen = user.LastSeenAt
+		}
+		presence = append(presence, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"presence": presence})
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"flux/internal/models"
+)
+
+// follow records that follower follows following
+func follow(t *testing.T, h *PresenceHandler, follower, following models.User) {
+	t.Helper()
+
+	if err := h.db.Create(&models.Friend{FollowerID: follower.ID, FollowingID: following.ID}).Error; err != nil {
+		t.Fatalf("follow: %v", err)
+	}
+}
+
+func TestGetPresenceOnlyShowsMutualFollowers(t *testing.T) {
+	h := NewPresenceHandler(newTestDB(t))
+	me := newTestUser(t, h.db, "me", "password123")
+	mutual := newTestUser(t, h.db, "mutual", "password123")
+	fan := newTestUser(t, h.db, "fan", "password123")
+	stranger := newTestUser(t, h.db, "stranger", "password123")
+
+	follow(t, h, me, mutual)
+	follow(t, h, mutual, me)
+	follow(t, h, fan, me)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/presence?ids=4,3,2,1", nil)
+	c.Set("user_id", me.ID)
+	h.GetPresence(c)
+	expectStatus(t, w, http.StatusOK)
+
+	var got []uint
+	for _, entry := range decode(t, w)["presence"].([]interface{}) {
+		got = append(got, uint(entry.(map[string]interface{})["user_id"].(float64)))
+	}
+	if len(got) != 2 || got[0] != mutual.ID || got[1] != me.ID {
+		t.Fatalf("presence returned for users %v, want [%d %d] (fan %d and stranger %d left out)", got, mutual.ID, me.ID, fan.ID, stranger.ID)
+	}
+}
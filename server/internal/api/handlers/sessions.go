@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"flux/internal/auth"
+	"flux/internal/chat"
+	"flux/internal/models"
+)
+
+// issueTokens stores a new refresh token for the session and returns it with a matching access token
+func issueTokens(tx *gorm.DB, user models.User, session models.Session) (string, string, error) {
+	rawRefresh, refreshHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken := models.RefreshToken{
+		SessionID: session.ID,
+		TokenHash: refreshHash,
+		ExpiresAt: time.Now().Add(auth.RefreshTokenTTL),
+	}
+	if err := tx.Create(&refreshToken).Error; err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := auth.GenerateAccessToken(user.ID, user.Username, session.JTI)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, rawRefresh, nil
+}
+
+// loginBlocked responds with 403 and returns true when the account may not start
+// or continue a session
+func loginBlocked(c *gin.Context, user models.User) bool {
+	if user.IsSuspended() {
+		response := gin.H{"error": "Account is " + user.Status, "status": user.Status}
+		if user.StatusReason != "" {
+			response["reason"] = user.StatusReason
+		}
+		if user.SuspendedUntil != nil {
+			response["suspended_until"] = user.SuspendedUntil
+		}
+		c.JSON(http.StatusForbidden, response)
+		return true
+	}
+
+	if user.MustResetPassword {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":                   "Password reset required, check your email for a reset link",
+			"password_reset_required": true,
+		})
+		return true
+	}
+
+	return false
+}
+
+// startSession records a new session for the user on the requesting device and issues its first tokens
+func (h *AuthHandler) startSession(c *gin.Context, user models.User, deviceID string) (gin.H, error) {
+	jti, err := auth.GenerateID()
+	if err != nil {
+		return nil, err
+	}
+
+	if deviceID == "" {
+		if deviceID, err = auth.GenerateID(); err != nil {
+			return nil, err
+		}
+	}
+
+	session := models.Session{
+		UserID:     user.ID,
+		JTI:        jti,
+		DeviceID:   deviceID,
+		UserAgent:  c.Request.UserAgent(),
+		IP:         c.ClientIP(),
+		LastSeenAt: time.Now(),
+	}
+
+	var accessToken, refreshToken string
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+
+		var err error
+		accessToken, refreshToken, err = issueTokens(tx, user, session)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"device_id":     deviceID,
+		"expires_in":    int(auth.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// revokeSessions marks the sessions as revoked and closes any sockets opened with them
+func revokeSessions(db *gorm.DB, sessions []models.Session) error {
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, 0, len(sessions))
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+	}
+
+	if err := db.Model(&models.Session{}).
+		Where("id IN ? AND revoked_at IS NULL", ids).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		chat.DisconnectSession(session.JTI)
+	}
+
+	return nil
+}
+
+// revokeUserSessions revokes every active session of a user except the one with exceptJTI
+func revokeUserSessions(db *gorm.DB, userID uint, exceptJTI string) error {
+	var sessions []models.Session
+	if err := db.Where("user_id = ? AND revoked_at IS NULL AND jti != ?", userID, exceptJTI).
+		Find(&sessions).Error; err != nil {
+		return err
+	}
+
+	return revokeSessions(db, sessions)
+}
+
+// ListSessions - List the active sessions of the authenticated user
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+	currentJTI, _ := c.Get("session_jti")
+
+	var sessions []models.Session
+	if err := h.db.Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	type SessionResponse struct {
+		models.Session
+		Current bool `json:"current"`
+	}
+
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{
+			Session: session,
+			Current: session.JTI == currentJTI,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": response})
+}
+
+// RevokeSession - Log out a single session of the authenticated user
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var session models.Session
+	if err := h.db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", c.Param("id"), userID).First(&session).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch session"})
+		}
+		return
+	}
+
+	if err := revokeSessions(h.db, []models.Session{session}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	fmt.Printf("RevokeSession - User %d revoked session %d\n", session.UserID, session.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
+
+// RevokeAllSessions - Log the authenticated user out everywhere
+func (h *AuthHandler) RevokeAllSessions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	if err := revokeUserSessions(h.db, userID.(uint), ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out from all sessions"})
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"flux/internal/auth"
+	"flux/internal/models"
+)
+
+// maxTokenLifetimeDays caps the expiry that can be requested for a personal access token
+const maxTokenLifetimeDays = 365
+
+// CreateTokenRequest represents the request to create a personal access token
+type CreateTokenRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays int      `json:"expires_in_days" binding:"min=0"` // 0 means the token never expires
+}
+
+// TokenResponse is a personal access token as listed to its owner
+type TokenResponse struct {
+	models.PersonalAccessToken
+	Scopes []string `json:"scopes"`
+}
+
+func newTokenResponse(token models.PersonalAccessToken) TokenResponse {
+	return TokenResponse{
+		PersonalAccessToken: token,
+		Scopes:              auth.ParseScopes(token.Scopes),
+	}
+}
+
+// ListTokens - List the authenticated user's personal access tokens
+func (h *AuthHandler) ListTokens(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var tokens []models.PersonalAccessToken
+	if err := h.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tokens"})
+		return
+	}
+
+	response := make([]TokenResponse, 0, len(tokens))
+	for _, token := range tokens {
+		response = append(response, newTokenResponse(token))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tokens": response, "available_scopes": auth.AllScopes})
+}
+
+// CreateToken - Create a personal access token; the token is only ever returned here
+func (h *AuthHandler) CreateToken(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req CreateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format: " + err.Error()})
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if !auth.ValidScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown scope %q", scope)})
+			return
+		}
+	}
+
+	if req.ExpiresInDays > maxTokenLifetimeDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Tokens can last at most %d days", maxTokenLifetimeDays)})
+		return
+	}
+
+	raw, _, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	raw = auth.PersonalAccessTokenPrefix + raw
+
+	token := models.PersonalAccessToken{
+		UserID:    userID.(uint),
+		Name:      req.Name,
+		TokenHash: auth.HashToken(raw),
+		Hint:      raw[len(raw)-4:],
+		Scopes:    strings.Join(req.Scopes, " "),
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := h.db.Create(&token).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Token created. Copy it now, it won't be shown again",
+		"token":   raw,
+		"details": newTokenResponse(token),
+	})
+}
+
+// RevokeToken - Delete one of the authenticated user's personal access tokens
+func (h *AuthHandler) RevokeToken(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var token models.PersonalAccessToken
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&token).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch token"})
+		}
+		return
+	}
+
+	if err := h.db.Delete(&token).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked successfully"})
+}
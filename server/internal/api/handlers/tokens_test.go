@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"flux/internal/api/middleware"
+	"flux/internal/auth"
+	"flux/internal/models"
+)
+
+// tokenTest is a router with the token, account and conversation routes wired
+// the way routes.SetupRoutes wires them
+type tokenTest struct {
+	h      *AuthHandler
+	router *gin.Engine
+}
+
+func newTokenTest(t *testing.T) *tokenTest {
+	t.Helper()
+
+	h := newTestAuthHandler(t)
+	account := &AccountHandler{db: h.db}
+	conversations := &ConversationHandler{db: h.db}
+
+	router := gin.New()
+	accountRoutes := router.Group("/auth")
+	accountRoutes.Use(middleware.AuthMiddleware(h.db), middleware.RequireSession())
+	{
+		accountRoutes.GET("/sessions", h.ListSessions)
+		accountRoutes.GET("/tokens", h.ListTokens)
+		accountRoutes.POST("/tokens", h.CreateToken)
+	}
+	meRoutes := router.Group("/me")
+	meRoutes.Use(middleware.AuthMiddleware(h.db), middleware.RequireSession())
+	{
+		meRoutes.GET("", account.GetMe)
+	}
+	protected := router.Group("/")
+	protected.Use(middleware.AuthMiddleware(h.db))
+	{
+		protected.GET("/conversations", middleware.RequireScope(auth.ScopeMessagesRead), conversations.ListConversations)
+		protected.POST("/conversations", middleware.RequireScope(auth.ScopeMessagesWrite), conversations.CreateGroup)
+	}
+	return &tokenTest{h: h, router: router}
+}
+
+// do sends a request with the bearer token and body encoded as JSON
+func (tk *tokenTest) do(t *testing.T, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	tk.router.ServeHTTP(w, req)
+	return w
+}
+
+// sessionToken starts a session for the user and returns its access token
+func sessionToken(t *testing.T, h *AuthHandler, user models.User) string {
+	t.Helper()
+
+	var response gin.H
+	serve(t, func(c *gin.Context) {
+		var err error
+		if response, err = h.startSession(c, user, ""); err != nil {
+			t.Fatalf("start session: %v", err)
+		}
+	}, http.MethodPost, 0, nil)
+	return response["token"].(string)
+}
+
+// createToken creates a personal access token through the API and returns it
+func (tk *tokenTest) createToken(t *testing.T, session string, req CreateTokenRequest) string {
+	t.Helper()
+
+	w := tk.do(t, http.MethodPost, "/auth/tokens", session, req)
+	expectStatus(t, w, http.StatusCreated)
+	return decode(t, w)["token"].(string)
+}
+
+func TestTokenIsStoredOnlyAsHash(t *testing.T) {
+	tk := newTokenTest(t)
+	user := newTestUser(t, tk.h.db, "alice", "password123")
+	raw := tk.createToken(t, sessionToken(t, tk.h, user), CreateTokenRequest{Name: "cli", Scopes: []string{auth.ScopeMessagesRead}})
+
+	if !strings.HasPrefix(raw, auth.PersonalAccessTokenPrefix) {
+		t.Fatalf("token %q doesn't have the %s prefix", raw, auth.PersonalAccessTokenPrefix)
+	}
+
+	var stored models.PersonalAccessToken
+	if err := tk.h.db.Where("user_id = ?", user.ID).First(&stored).Error; err != nil {
+		t.Fatalf("load token: %v", err)
+	}
+	if stored.TokenHash != auth.HashToken(raw) || stored.Hint != raw[len(raw)-4:] {
+		t.Fatalf("stored token %+v doesn't match the hash and hint of the issued one", stored)
+	}
+
+	// No column holds the token itself
+	var matches int64
+	tk.h.db.Model(&models.PersonalAccessToken{}).
+		Where("token_hash = ? OR name = ? OR hint = ? OR scopes = ?", raw, raw, raw, raw).Count(&matches)
+	if matches != 0 {
+		t.Fatal("the raw token was stored")
+	}
+
+	// And listing the tokens never shows the hash
+	w := tk.do(t, http.MethodGet, "/auth/tokens", sessionToken(t, tk.h, user), nil)
+	expectStatus(t, w, http.StatusOK)
+	if strings.Contains(w.Body.String(), stored.TokenHash) || strings.Contains(w.Body.String(), raw) {
+		t.Fatalf("token list leaks the token: %s", w.Body.String())
+	}
+}
+
+func TestTokenIsLimitedToItsScopes(t *testing.T) {
+	tk := newTokenTest(t)
+	user := newTestUser(t, tk.h.db, "alice", "password123")
+	raw := tk.createToken(t, sessionToken(t, tk.h, user), CreateTokenRequest{Name: "reader", Scopes: []string{auth.ScopeMessagesRead}})
+
+	expectStatus(t, tk.do(t, http.MethodGet, "/conversations", raw, nil), http.StatusOK)
+
+	w := tk.do(t, http.MethodPost, "/conversations", raw, gin.H{"name": "group", "member_ids": []uint{user.ID}})
+	expectStatus(t, w, http.StatusForbidden)
+
+	var groups int64
+	tk.h.db.Model(&models.Conversation{}).Count(&groups)
+	if groups != 0 {
+		t.Fatal("a token without messages:write created a group")
+	}
+}
+
+func TestTokenCannotManageTheAccount(t *testing.T) {
+	tk := newTokenTest(t)
+	user := newTestUser(t, tk.h.db, "alice", "password123")
+	session := sessionToken(t, tk.h, user)
+	raw := tk.createToken(t, session, CreateTokenRequest{Name: "everything", Scopes: auth.AllScopes})
+
+	for _, path := range []string{"/auth/sessions", "/auth/tokens", "/me"} {
+		expectStatus(t, tk.do(t, http.MethodGet, path, raw, nil), http.StatusForbidden)
+		expectStatus(t, tk.do(t, http.MethodGet, path, session, nil), http.StatusOK)
+	}
+
+	// In particular, a token can't mint itself a broader or longer-lived one
+	w := tk.do(t, http.MethodPost, "/auth/tokens", raw, CreateTokenRequest{Name: "escalated", Scopes: auth.AllScopes})
+	expectStatus(t, w, http.StatusForbidden)
+}
+
+func TestExpiredTokenIsRefused(t *testing.T) {
+	tk := newTokenTest(t)
+	user := newTestUser(t, tk.h.db, "alice", "password123")
+	raw := tk.createToken(t, sessionToken(t, tk.h, user), CreateTokenRequest{Name: "short", Scopes: []string{auth.ScopeMessagesRead}, ExpiresInDays: 1})
+
+	expectStatus(t, tk.do(t, http.MethodGet, "/conversations", raw, nil), http.StatusOK)
+
+	tk.h.db.Model(&models.PersonalAccessToken{}).Where("user_id = ?", user.ID).
+		Update("expires_at", time.Now().Add(-time.Minute))
+	expectStatus(t, tk.do(t, http.MethodGet, "/conversations", raw, nil), http.StatusUnauthorized)
+}
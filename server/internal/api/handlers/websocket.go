@@ -1,10 +1,17 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"flux/internal/api/middleware"
+	"flux/internal/auth"
 	"flux/internal/chat"
+	"flux/internal/messaging"
 	"flux/internal/models"
 
 	"github.com/gin-gonic/gin"
@@ -13,21 +20,85 @@ import (
 )
 
 type WebsocketHandler struct {
-	db *gorm.DB
+	db         *gorm.DB
+	messages   *messaging.Service
+	events     *messaging.EventLog
+	dispatcher *chat.Dispatcher
 }
 
 func NewWebsocketHandler(db *gorm.DB) *WebsocketHandler {
-	return &WebsocketHandler{db: db}
+	h := &WebsocketHandler{db: db, messages: messaging.NewService(db), events: messaging.NewEventLog(db), dispatcher: chat.NewDispatcher()}
+	h.dispatcher.Handle(chat.EventMessageSend, h.handleMessageSend)
+	h.dispatcher.Handle(chat.EventRead, h.handleRead)
+	h.dispatcher.Handle(chat.EventTyping, h.handleTyping)
+	h.dispatcher.Handle(chat.EventPresence, h.handlePresence)
+
+	// Keep what is published to users so clients can resume after a dropped connection,
+	// for as long as EventRetention
+	chat.DefaultHub.SetEventLog(h.events)
+	h.events.Start()
+	chat.DefaultHub.SetConfig(chat.ConfigFromEnv())
+
+	// Tell mutual followers when someone comes online, goes idle or leaves
+	chat.DefaultHub.SetPresenceHandler(func(userID uint, status string) {
+		publishPresence(db, userID, status)
+	})
+	return h
 }
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { 
-		// In production, you should validate the origin properly
-		return true 
+	CheckOrigin: func(r *http.Request) bool {
+		// Browsers always send an Origin; clients that don't aren't subject to CSRF
+		origin := r.Header.Get("Origin")
+		return origin == "" || middleware.OriginAllowed(origin)
 	},
 }
 
-// HandleConnection - Handle WebSocket connection for authenticated user
+// IssueTicket - Issue a single-use ticket for opening a WebSocket from this origin
+func (h *WebsocketHandler) IssueTicket(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	origin := c.GetHeader("Origin")
+	if origin != "" && !middleware.OriginAllowed(origin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Origin not allowed"})
+		return
+	}
+
+	raw, hash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create ticket"})
+		return
+	}
+
+	ticket := models.WSTicket{
+		UserID:     userID.(uint),
+		SessionJTI: c.GetString("session_jti"),
+		TicketHash: hash,
+		Origin:     origin,
+		ExpiresAt:  time.Now().Add(auth.WSTicketTTL),
+	}
+	if err := h.db.Create(&ticket).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create ticket"})
+		return
+	}
+
+	// Tickets are useless once expired, so clear out old ones as we go
+	h.db.Unscoped().Where("expires_at < ?", time.Now().Add(-time.Hour)).Delete(&models.WSTicket{})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"ticket":     raw,
+		"expires_in": int(auth.WSTicketTTL.Seconds()),
+	})
+}
+
+// HandleConnection - Handle WebSocket connection for authenticated user. With
+// ?since=<seq> the events after seq are replayed before live delivery starts.
 func (h *WebsocketHandler) HandleConnection(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -39,72 +110,232 @@ func (h *WebsocketHandler) HandleConnection(c *gin.Context) {
 
 	// Convert user_id to uint
 	userIDValue := userID.(uint)
+	sessionJTI := c.GetString("session_jti")
+
+	var since *uint64
+	if raw, ok := c.GetQuery("since"); ok {
+		seq, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since format"})
+			return
+		}
+		since = &seq
+	}
 
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		fmt.Printf("WS Upgrade failed for user %d: %v\n", userIDValue, err)
 		return
 	}
-	defer conn.Close()
 
-	// Register client
-	chat.Clients[conn] = userIDValue
+	// Register client first so nothing published from here on is missed; it is
+	// only queued until the writer starts
+	client := chat.DefaultHub.NewClient(conn, userIDValue, sessionJTI)
+	if since != nil {
+		client.ResumeFrom(*since)
+		// Messages that reach the device through the replay have been delivered
+		client.OnReplay(func(events []chat.Envelope) {
+			messaging.MarkReplayedDelivered(h.db, userIDValue, events)
+		})
+	}
+	chat.DefaultHub.Register(client)
 	fmt.Printf("User %d connected via WebSocket\n", userIDValue)
 
-	// Send initial connection confirmation
-	confirmMsg := models.Message{
-		SenderID:   0, // System message
-		ReceiverID: userIDValue,
-		Content:    "Connected to chat server",
+	latest, err := h.events.Latest(userIDValue)
+	if err != nil {
+		fmt.Printf("WS failed to load latest event of user %d: %v\n", userIDValue, err)
 	}
-	conn.WriteJSON(confirmMsg)
 
-	// Listen for messages
-	for {
-		var msg models.Message
-		err := conn.ReadJSON(&msg)
-		if err != nil {
-			fmt.Printf("WS read error for user %d: %v\n", userIDValue, err)
-			delete(chat.Clients, conn)
-			break
+	// Tell the client who it is connected as and which protocol to speak. The writer
+	// isn't running yet, so this goes out before any replayed or live event.
+	if err := client.WriteNow(chat.NewEnvelope(chat.EventConnected, "", chat.ConnectedPayload{
+		UserID:  userIDValue,
+		Version: chat.ProtocolVersion,
+		Seq:     latest,
+	})); err != nil {
+		fmt.Printf("WS failed to greet user %d: %v\n", userIDValue, err)
+		chat.DefaultHub.Unregister(client, websocket.CloseAbnormalClosure, "write failed")
+		conn.Close()
+		return
+	}
+
+	// From here on only the client's writer goroutine writes to conn
+	go client.WritePump()
+
+	// Listen for events until the connection closes, breaks or goes idle
+	client.ReadPump(func(data []byte) {
+		h.dispatcher.Dispatch(client, data)
+	})
+
+	fmt.Printf("User %d disconnected from WebSocket\n", userIDValue)
+}
+
+// handleMessageSend sends a message.send from the socket. A retried client_msg_id is
+// acked here with the original message.
+func (h *WebsocketHandler) handleMessageSend(client *chat.Client, env chat.Envelope) error {
+	var payload chat.MessageSendPayload
+	if err := json.Unmarshal(env.Payload, &payload); err != nil {
+		return chat.NewError(chat.ErrCodeBadRequest, "Invalid message.send payload")
+	}
+
+	// Validate message sender matches authenticated user
+	if payload.SenderID != 0 && payload.SenderID != client.UserID {
+		fmt.Printf("Invalid sender ID from user %d: attempted to send as %d\n", client.UserID, payload.SenderID)
+		return chat.NewError(chat.ErrCodeSpoofedSender, "You can only send messages as yourself")
+	}
+
+	msg, duplicate, err := h.messages.Send(messaging.SendRequest{
+		SenderID:       client.UserID,
+		ReceiverID:     payload.ReceiverID,
+		ConversationID: payload.ConversationID,
+		Content:        payload.Content,
+		ClientMsgID:    payload.ClientMsgID,
+		ReplyToID:      payload.ReplyToID,
+		Origin:         client,
+		AckID:          env.ID,
+	})
+	switch {
+	case errors.Is(err, messaging.ErrEmptyContent):
+		return chat.NewError(chat.ErrCodeBadRequest, "Message content can't be empty")
+	case errors.Is(err, messaging.ErrClientMsgIDTooLong), errors.Is(err, messaging.ErrDuplicateMismatch), errors.Is(err, messaging.ErrInvalidReply):
+		return chat.NewError(chat.ErrCodeBadRequest, err.Error())
+	case errors.Is(err, messaging.ErrNoReceiver), errors.Is(err, messaging.ErrReceiverNotFound):
+		fmt.Printf("Receiver %d not found for message from user %d\n", payload.ReceiverID, client.UserID)
+		return chat.NewError(chat.ErrCodeInvalidReceiver, "Receiver not found")
+	case errors.Is(err, messaging.ErrNotMember):
+		return chat.NewError(chat.ErrCodeNotMember, "You're not in that conversation")
+	case err != nil:
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+
+	// A new message is acked by the hub along with its sequence number
+	if !duplicate {
+		return nil
+	}
+	client.Send(chat.NewEnvelope(chat.EventMessageAck, env.ID, chat.MessageAckPayload{
+		ClientMsgID: payload.ClientMsgID,
+		Message:     msg,
+	}))
+	return nil
+}
+
+// handleRead marks messages from a conversation partner as read
+func (h *WebsocketHandler) handleRead(client *chat.Client, env chat.Envelope) error {
+	var payload chat.ReadPayload
+	if err := json.Unmarshal(env.Payload, &payload); err != nil || (payload.UserID == 0 && payload.ConversationID == 0) || payload.UpToID == 0 {
+		return chat.NewError(chat.ErrCodeBadRequest, "read needs user_id or conversation_id, and up_to_id")
+	}
+
+	if payload.ConversationID != 0 {
+		var member models.ConversationMember
+		if err := h.db.Where("conversation_id = ? AND user_id = ?", payload.ConversationID, client.UserID).First(&member).Error; err != nil {
+			return chat.NewError(chat.ErrCodeNotMember, "You're not in that conversation")
 		}
 
-		// Validate message sender matches authenticated user
-		if msg.SenderID != userIDValue {
-			fmt.Printf("Invalid sender ID from user %d: attempted to send as %d\n", userIDValue, msg.SenderID)
-			continue
+		var conversation models.Conversation
+		if err := h.db.First(&conversation, member.ConversationID).Error; err != nil {
+			return fmt.Errorf("failed to load conversation: %w", err)
+		}
+		if conversation.IsGroup() {
+			if err := messaging.MarkGroupRead(h.db, member, payload.UpToID, client); err != nil {
+				return fmt.Errorf("failed to mark conversation read: %w", err)
+			}
+			return nil
+		}
+
+		partnerID, err := messaging.DirectPartner(h.db, conversation.ID, client.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to load conversation: %w", err)
 		}
+		payload.UserID = partnerID
+	}
 
-		// Validate message content
-		if msg.Content == "" || msg.ReceiverID == 0 {
-			fmt.Printf("Invalid message from user %d: empty content or invalid receiver\n", userIDValue)
-			continue
+	if _, err := messaging.MarkRead(h.db, client.UserID, payload.UserID, payload.UpToID, client); err != nil {
+		return fmt.Errorf("failed to mark messages read: %w", err)
+	}
+	return nil
+}
+
+// handleTyping forwards a typing start or stop to the conversation partner, or to
+// the other members of a group. Only people the sender shares a conversation with
+// are told.
+func (h *WebsocketHandler) handleTyping(client *chat.Client, env chat.Envelope) error {
+	var payload chat.TypingPayload
+	if err := json.Unmarshal(env.Payload, &payload); err != nil || (payload.UserID == 0 && payload.ConversationID == 0) {
+		return chat.NewError(chat.ErrCodeBadRequest, "typing needs the user_id of someone else or a conversation_id")
+	}
+	if payload.State != chat.TypingStart && payload.State != chat.TypingStop {
+		return chat.NewError(chat.ErrCodeBadRequest, "typing state must be start or stop")
+	}
+
+	if payload.ConversationID != 0 {
+		var member models.ConversationMember
+		if err := h.db.Where("conversation_id = ? AND user_id = ?", payload.ConversationID, client.UserID).First(&member).Error; err != nil {
+			return chat.NewError(chat.ErrCodeNotMember, "You're not in that conversation")
 		}
 
-		// Check if receiver exists
-		var receiver models.User
-		if err := h.db.First(&receiver, msg.ReceiverID).Error; err != nil {
-			fmt.Printf("Receiver %d not found for message from user %d\n", msg.ReceiverID, userIDValue)
-			continue
+		var conversation models.Conversation
+		if err := h.db.First(&conversation, member.ConversationID).Error; err != nil {
+			return fmt.Errorf("failed to load conversation: %w", err)
 		}
+		if conversation.IsGroup() {
+			if payload.State == chat.TypingStop {
+				chat.DefaultHub.StopGroupTyping(client.UserID, conversation.ID)
+				return nil
+			}
 
-		// Save message to database
-		if err := h.db.Create(&msg).Error; err != nil {
-			fmt.Printf("Failed to save message from user %d: %v\n", userIDValue, err)
-			continue
+			var memberIDs []uint
+			if err := h.db.Model(&models.ConversationMember{}).Where("conversation_id = ?", conversation.ID).
+				Pluck("user_id", &memberIDs).Error; err != nil {
+				return fmt.Errorf("failed to load members: %w", err)
+			}
+			chat.DefaultHub.StartGroupTyping(client.UserID, conversation.ID, memberIDs)
+			return nil
 		}
 
-		// Preload sender and receiver data
-		if err := h.db.Preload("Sender").Preload("Receiver").First(&msg, msg.ID).Error; err != nil {
-			fmt.Printf("Failed to preload message data: %v\n", err)
-			continue
+		partnerID, err := messaging.DirectPartner(h.db, conversation.ID, client.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to load conversation: %w", err)
+		}
+		payload.UserID = partnerID
+	} else {
+		var shared int64
+		if err := h.db.Model(&models.Conversation{}).Where("direct_key = ?", models.DirectKey(client.UserID, payload.UserID)).
+			Count(&shared).Error; err != nil {
+			return fmt.Errorf("failed to load conversation: %w", err)
 		}
+		if shared == 0 {
+			return chat.NewError(chat.ErrCodeNotMember, "You don't have a conversation with that user")
+		}
+	}
 
-		fmt.Printf("Message saved and broadcasting from user %d to user %d\n", msg.SenderID, msg.ReceiverID)
-		
-		// Broadcast message
-		chat.Broadcast <- msg
+	// Nobody needs to know you are typing a note to yourself
+	if payload.UserID == client.UserID {
+		return nil
 	}
 
-	fmt.Printf("User %d disconnected from WebSocket\n", userIDValue)
+	if payload.State == chat.TypingStart {
+		chat.DefaultHub.StartTyping(client.UserID, payload.UserID)
+	} else {
+		chat.DefaultHub.StopTyping(client.UserID, payload.UserID)
+	}
+	return nil
+}
+
+// handlePresence lets a connection report that it went idle or became active again
+func (h *WebsocketHandler) handlePresence(client *chat.Client, env chat.Envelope) error {
+	var payload chat.PresencePayload
+	if err := json.Unmarshal(env.Payload, &payload); err != nil {
+		return chat.NewError(chat.ErrCodeBadRequest, "Invalid presence payload")
+	}
+
+	switch payload.Status {
+	case chat.StatusAway:
+		chat.DefaultHub.SetAway(client, true)
+	case chat.StatusOnline:
+		chat.DefaultHub.SetAway(client, false)
+	default:
+		return chat.NewError(chat.ErrCodeBadRequest, "presence status must be online or away")
+	}
+	return nil
 }
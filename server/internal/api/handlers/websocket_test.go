@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"flux/internal/api/middleware"
+	"flux/internal/auth"
+	"flux/internal/chat"
+	"flux/internal/messaging"
+	"flux/internal/models"
+)
+
+// typing sends a typing event from the user and returns the handler's error code,
+// empty if it was accepted
+func typing(t *testing.T, h *WebsocketHandler, from models.User, payload chat.TypingPayload) string {
+	t.Helper()
+
+	data, _ := json.Marshal(payload)
+	client := chat.DefaultHub.NewClient(nil, from.ID, "")
+	err := h.handleTyping(client, chat.Envelope{V: chat.ProtocolVersion, Type: chat.EventTyping, Payload: data})
+	if err == nil {
+		return ""
+	}
+	chatErr, ok := err.(*chat.Error)
+	if !ok {
+		t.Fatalf("typing failed: %v", err)
+	}
+	return chatErr.Code
+}
+
+func TestTypingNeedsSharedConversation(t *testing.T) {
+	h := &WebsocketHandler{db: newTestDB(t)}
+	alice := newTestUser(t, h.db, "alice", "password123")
+	bob := newTestUser(t, h.db, "bob", "password123")
+	mallory := newTestUser(t, h.db, "mallory", "password123")
+
+	direct, err := messaging.DirectConversation(h.db, alice.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("create conversation: %v", err)
+	}
+	group := newTestGroup(t, h.db, alice, bob)
+
+	tests := []struct {
+		name    string
+		from    models.User
+		payload chat.TypingPayload
+		want    string
+	}{
+		{"partner by user_id", alice, chat.TypingPayload{UserID: bob.ID, State: chat.TypingStop}, ""},
+		{"partner by conversation_id", bob, chat.TypingPayload{ConversationID: direct.ID, State: chat.TypingStop}, ""},
+		{"group member", alice, chat.TypingPayload{ConversationID: group.ID, State: chat.TypingStop}, ""},
+		{"stranger by user_id", mallory, chat.TypingPayload{UserID: alice.ID, State: chat.TypingStart}, chat.ErrCodeNotMember},
+		{"stranger to direct conversation", mallory, chat.TypingPayload{ConversationID: direct.ID, State: chat.TypingStart}, chat.ErrCodeNotMember},
+		{"stranger to group", mallory, chat.TypingPayload{ConversationID: group.ID, State: chat.TypingStart}, chat.ErrCodeNotMember},
+		{"nobody", alice, chat.TypingPayload{State: chat.TypingStart}, chat.ErrCodeBadRequest},
+		{"unknown state", alice, chat.TypingPayload{UserID: bob.ID, State: "maybe"}, chat.ErrCodeBadRequest},
+	}
+	for _, tt := range tests {
+		if got := typing(t, h, tt.from, tt.payload); got != tt.want {
+			t.Errorf("%s: code = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+const (
+	appOrigin   = "https://app.example.com"
+	otherOrigin = "https://other.example.com"
+)
+
+// ticketTest is a router with the ticket and connect routes wired the way
+// routes.SetupRoutes wires them, except that a connection that gets past the
+// ticket check is answered with the user it was authenticated as
+type ticketTest struct {
+	h      *AuthHandler
+	router *gin.Engine
+}
+
+func newTicketTest(t *testing.T) *ticketTest {
+	t.Helper()
+	t.Setenv("ALLOWED_ORIGINS", appOrigin+","+otherOrigin)
+
+	h := newTestAuthHandler(t)
+	ws := &WebsocketHandler{db: h.db}
+
+	router := gin.New()
+	router.POST("/ws/ticket", middleware.AuthMiddleware(h.db), middleware.RequireSession(), ws.IssueTicket)
+	router.GET("/ws/connect", middleware.WSAuthMiddleware(h.db), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user_id": c.GetUint("user_id")})
+	})
+	return &ticketTest{h: h, router: router}
+}
+
+// issue asks for a ticket with the access token from the origin
+func (wt *ticketTest) issue(t *testing.T, token, origin string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/ws/ticket", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	w := httptest.NewRecorder()
+	wt.router.ServeHTTP(w, req)
+	return w
+}
+
+// ticket issues a ticket with the access token from the origin and returns it
+func (wt *ticketTest) ticket(t *testing.T, token, origin string) string {
+	t.Helper()
+
+	w := wt.issue(t, token, origin)
+	expectStatus(t, w, http.StatusCreated)
+	return decode(t, w)["ticket"].(string)
+}
+
+// connect opens /ws/connect with the ticket from the origin
+func (wt *ticketTest) connect(t *testing.T, ticket, origin string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/ws/connect?ticket="+url.QueryEscape(ticket), nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	w := httptest.NewRecorder()
+	wt.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestTicketIsSingleUse(t *testing.T) {
+	wt := newTicketTest(t)
+	alice := newTestUser(t, wt.h.db, "alice", "password123")
+	ticket := wt.ticket(t, sessionToken(t, wt.h, alice), appOrigin)
+
+	w := wt.connect(t, ticket, appOrigin)
+	expectStatus(t, w, http.StatusOK)
+	if decode(t, w)["user_id"] != float64(alice.ID) {
+		t.Fatalf("ticket connected as %v, want alice", decode(t, w)["user_id"])
+	}
+
+	expectStatus(t, wt.connect(t, ticket, appOrigin), http.StatusUnauthorized)
+	expectStatus(t, wt.connect(t, "not-a-ticket", appOrigin), http.StatusUnauthorized)
+}
+
+func TestTicketExpires(t *testing.T) {
+	wt := newTicketTest(t)
+	alice := newTestUser(t, wt.h.db, "alice", "password123")
+
+	w := wt.issue(t, sessionToken(t, wt.h, alice), appOrigin)
+	expectStatus(t, w, http.StatusCreated)
+	if expiresIn := decode(t, w)["expires_in"]; expiresIn != float64(30) {
+		t.Fatalf("expires_in = %v, want 30", expiresIn)
+	}
+
+	var stored models.WSTicket
+	wt.h.db.Where("user_id = ?", alice.ID).First(&stored)
+	if left := time.Until(stored.ExpiresAt); left <= 25*time.Second || left > auth.WSTicketTTL {
+		t.Fatalf("ticket expires in %s, want about %s", left, auth.WSTicketTTL)
+	}
+
+	// Thirty seconds later it no longer opens a connection
+	wt.h.db.Model(&stored).Update("expires_at", time.Now().Add(-time.Second))
+	expectStatus(t, wt.connect(t, decode(t, w)["ticket"].(string), appOrigin), http.StatusUnauthorized)
+}
+
+func TestTicketIsBoundToItsOrigin(t *testing.T) {
+	wt := newTicketTest(t)
+	alice := newTestUser(t, wt.h.db, "alice", "password123")
+	token := sessionToken(t, wt.h, alice)
+
+	expectStatus(t, wt.connect(t, wt.ticket(t, token, appOrigin), otherOrigin), http.StatusForbidden)
+	expectStatus(t, wt.connect(t, wt.ticket(t, token, appOrigin), ""), http.StatusForbidden)
+	expectStatus(t, wt.connect(t, wt.ticket(t, token, ""), appOrigin), http.StatusForbidden)
+
+	// Pages elsewhere can't get a ticket at all
+	expectStatus(t, wt.issue(t, token, "https://evil.example.com"), http.StatusForbidden)
+}
+
+func TestTicketIsRejectedAfterSessionRevoked(t *testing.T) {
+	wt := newTicketTest(t)
+	alice := newTestUser(t, wt.h.db, "alice", "password123")
+	token := sessionToken(t, wt.h, alice)
+	ticket := wt.ticket(t, token, appOrigin)
+
+	w := serve(t, wt.h.RevokeAllSessions, http.MethodDelete, alice.ID, nil)
+	expectStatus(t, w, http.StatusOK)
+
+	expectStatus(t, wt.connect(t, ticket, appOrigin), http.StatusUnauthorized)
+	expectStatus(t, wt.issue(t, token, appOrigin), http.StatusUnauthorized)
+}
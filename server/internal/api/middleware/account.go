@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"flux/internal/auth"
+	"flux/internal/models"
+)
+
+// loadActiveUser loads the user a token was issued to and rejects the request if
+// the account no longer exists or is suspended
+func loadActiveUser(c *gin.Context, db *gorm.DB, userID uint) (models.User, bool) {
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		fmt.Println("Token user not found:", userID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		c.Abort()
+		return user, false
+	}
+
+	return user, requireActiveAccount(c, user)
+}
+
+// requireActiveAccount aborts with 403 when the account is banned, suspended or
+// locked until its password is reset
+func requireActiveAccount(c *gin.Context, user models.User) bool {
+	if user.MustResetPassword {
+		fmt.Printf("Rejecting request from user %d pending a password reset\n", user.ID)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Password reset required", "password_reset_required": true})
+		c.Abort()
+		return false
+	}
+
+	if !user.IsSuspended() {
+		return true
+	}
+
+	fmt.Printf("Rejecting request from %s user %d\n", user.Status, user.ID)
+	response := gin.H{"error": "Account is " + user.Status, "status": user.Status}
+	if user.StatusReason != "" {
+		response["reason"] = user.StatusReason
+	}
+	if user.SuspendedUntil != nil {
+		response["suspended_until"] = user.SuspendedUntil
+	}
+	c.JSON(http.StatusForbidden, response)
+	c.Abort()
+	return false
+}
+
+// RequirePermission rejects users whose role doesn't grant the permission
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !auth.HasPermission(c.GetString("user_role"), permission) {
+			fmt.Printf("User %v is missing permission %s for %s\n", c.GetUint("user_id"), permission, c.Request.URL.Path)
+			c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to do that"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
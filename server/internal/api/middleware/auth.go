@@ -1,18 +1,20 @@
 package middleware
 
 import (
-	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+
+	"flux/internal/auth"
+	"flux/internal/models"
 )
 
 // AuthMiddleware verifies the JWT token and sets user information in the context
-func AuthMiddleware() gin.HandlerFunc {
+func AuthMiddleware(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Add debug output
 		fmt.Println("Auth middleware processing request:", c.Request.URL.Path)
@@ -35,28 +37,32 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Get the token string
-		tokenString := parts[1]
-		fmt.Println("Token received:", tokenString[:10], "...")
+		// Personal access tokens are opaque and only carry the scopes they were granted
+		if strings.HasPrefix(parts[1], auth.PersonalAccessTokenPrefix) {
+			token, err := authenticatePersonalAccessToken(db, parts[1])
+			if err != nil {
+				fmt.Println("Personal access token error:", err)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+				c.Abort()
+				return
+			}
 
-		// Parse and validate the token
-		claims := &jwt.MapClaims{}
+			if !requireActiveAccount(c, token.User) {
+				return
+			}
 
-		// Get the JWT secret key from environment variable or use a default one
-		secretKey := os.Getenv("JWT_SECRET_KEY")
-		if secretKey == "" {
-			secretKey = "your-secret-key-change-in-production"
-		}
+			fmt.Println("Setting user_id in context from personal access token:", token.UserID)
+			c.Set("user_id", token.UserID)
+			c.Set("username", token.User.Username)
+			c.Set("user_role", token.User.Role)
+			c.Set("token_scopes", auth.ParseScopes(token.Scopes))
 
-		// Parse the token
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			// Validate the alg
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("unexpected signing method")
-			}
-			return []byte(secretKey), nil
-		})
+			c.Next()
+			return
+		}
 
+		// Parse and validate the token
+		claims, err := auth.ParseAccessToken(parts[1])
 		if err != nil {
 			fmt.Println("Token parsing error:", err)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
@@ -64,43 +70,47 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		if !token.Valid {
-			fmt.Println("Token is invalid")
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		// Reject tokens whose session has been logged out or revoked
+		if !sessionActive(db, claims.ID) {
+			fmt.Println("Session has been revoked:", claims.ID)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
 			c.Abort()
 			return
 		}
-
-		// Set user information in the context
-		userID, ok := (*claims)["user_id"]
+		
+		user, ok := loadActiveUser(c, db, claims.UserID)
 		if !ok {
-			fmt.Println("No user_id found in token claims")
-			fmt.Println("Available claims:", *claims)
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-			c.Abort()
-			return
-		}
-
-		// Convert user_id to uint and set in context
-		var userIDValue uint
-		switch v := userID.(type) {
-		case float64:
-			userIDValue = uint(v)
-		case int:
-			userIDValue = uint(v)
-		case uint:
-			userIDValue = v
-		default:
-			fmt.Printf("Unexpected user_id type: %T, value: %v\n", userID, userID)
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user_id format in token"})
-			c.Abort()
 			return
 		}
 		
-		fmt.Println("Setting user_id in context:", userIDValue)
-		c.Set("user_id", userIDValue)
-		c.Set("username", (*claims)["username"])
+		fmt.Println("Setting user_id in context:", claims.UserID)
+		c.Set("user_id", claims.UserID)
+		c.Set("username", user.Username)
+		c.Set("user_role", user.Role)
+		c.Set("session_jti", claims.ID)
 
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// sessionLastSeenInterval limits how often a session's last-seen time is written
+const sessionLastSeenInterval = time.Minute
+
+// sessionActive reports whether the session behind a token is still live and records activity on it
+func sessionActive(db *gorm.DB, jti string) bool {
+	var session models.Session
+	if err := db.Where("jti = ?", jti).First(&session).Error; err != nil {
+		fmt.Println("Failed to find session:", err)
+		return false
+	}
+
+	if session.RevokedAt != nil {
+		return false
+	}
+
+	if time.Since(session.LastSeenAt) > sessionLastSeenInterval {
+		db.Model(&session).UpdateColumn("last_seen_at", time.Now())
+	}
+
+	return true
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"os"
+	"strings"
+)
+
+// defaultAllowedOrigins are the frontend dev server addresses
+var defaultAllowedOrigins = []string{"http://localhost:5173", "http://127.0.0.1:5173"}
+
+// AllowedOrigins returns the browser origins allowed to call the API and open
+// WebSockets, from the comma separated ALLOWED_ORIGINS
+func AllowedOrigins() []string {
+	raw := os.Getenv("ALLOWED_ORIGINS")
+	if raw == "" {
+		return defaultAllowedOrigins
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(raw, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// OriginAllowed reports whether origin is one of the allowed origins
+func OriginAllowed(origin string) bool {
+	for _, allowed := range AllowedOrigins() {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
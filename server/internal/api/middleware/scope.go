@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"flux/internal/auth"
+	"flux/internal/models"
+)
+
+// tokenLastUsedInterval limits how often a personal access token's last-used time is written
+const tokenLastUsedInterval = time.Minute
+
+// authenticatePersonalAccessToken looks up a personal access token and records its use
+func authenticatePersonalAccessToken(db *gorm.DB, raw string) (*models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+	if err := db.Preload("User").Where("token_hash = ?", auth.HashToken(raw)).First(&token).Error; err != nil {
+		return nil, errors.New("unknown personal access token")
+	}
+
+	if token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt) {
+		return nil, errors.New("personal access token expired")
+	}
+
+	if token.LastUsedAt == nil || time.Since(*token.LastUsedAt) > tokenLastUsedInterval {
+		db.Model(&token).UpdateColumn("last_used_at", time.Now())
+	}
+
+	return &token, nil
+}
+
+// HasScope reports whether the request may use the given scope. Session tokens
+// carry every scope; personal access tokens only the ones they were granted.
+func HasScope(c *gin.Context, scope string) bool {
+	scopes, limited := c.Get("token_scopes")
+	if !limited {
+		return true
+	}
+
+	for _, s := range scopes.([]string) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireScope rejects personal access tokens that were not granted the scope
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasScope(c, scope) {
+			fmt.Printf("Token is missing scope %s for %s\n", scope, c.Request.URL.Path)
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Token does not have the %s scope", scope)})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireSession rejects personal access tokens, for routes that manage the account itself
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("session_jti") == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint requires a logged-in session"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
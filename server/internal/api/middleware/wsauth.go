@@ -1,77 +1,95 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+
+	"flux/internal/auth"
+	"flux/internal/models"
 )
 
-// WSAuthMiddleware verifies JWT token from query parameter for WebSocket connections
-func WSAuthMiddleware() gin.HandlerFunc {
+var errTicketUsed = errors.New("ticket already used")
+
+// WSAuthMiddleware authenticates WebSocket connections with a single-use ticket from
+// POST /ws/ticket, so no long-lived token ever ends up in a URL
+func WSAuthMiddleware(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		fmt.Println("WebSocket auth middleware processing request:", c.Request.URL.Path)
-		
-		// Get token from query parameter
-		token := c.Query("token")
-		if token == "" {
-			fmt.Println("No token found in query parameters")
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token required in query parameter"})
+
+		// Get ticket from query parameter
+		raw := c.Query("ticket")
+		if raw == "" {
+			fmt.Println("No ticket found in query parameters")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Ticket required in query parameter"})
 			c.Abort()
 			return
 		}
 
-		// Parse and validate the token
-		jwtSecret := os.Getenv("JWT_SECRET_KEY")
-		if jwtSecret == "" {
-			jwtSecret = "your-secret-key-change-in-production" // Match the default from auth.go
-		}
+		// Claim the ticket; the used_at check makes sure only one connection can
+		var ticket models.WSTicket
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("ticket_hash = ?", auth.HashToken(raw)).First(&ticket).Error; err != nil {
+				return err
+			}
 
-		parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-			// Make sure the signing method is HMAC
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			result := tx.Model(&models.WSTicket{}).
+				Where("id = ? AND used_at IS NULL", ticket.ID).
+				Update("used_at", time.Now())
+			if result.Error != nil {
+				return result.Error
 			}
-			return []byte(jwtSecret), nil
+			if result.RowsAffected == 0 {
+				return errTicketUsed
+			}
+			return nil
 		})
-
 		if err != nil {
-			fmt.Printf("Token parsing error: %v\n", err)
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			fmt.Printf("WebSocket ticket rejected: %v\n", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid ticket"})
 			c.Abort()
 			return
 		}
 
-		// Check if token is valid and extract claims
-		if claims, ok := parsedToken.Claims.(jwt.MapClaims); ok && parsedToken.Valid {
-			// Extract user information from token
-			userID := claims["user_id"]
-			username := claims["username"]
-
-			// Convert userID to proper type if needed
-			var userIDFloat float64
-			if id, ok := userID.(float64); ok {
-				userIDFloat = id
-			} else {
-				fmt.Println("Invalid user_id type in token")
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
-				c.Abort()
-				return
-			}
+		if time.Now().After(ticket.ExpiresAt) {
+			fmt.Println("WebSocket ticket expired for user", ticket.UserID)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Ticket expired"})
+			c.Abort()
+			return
+		}
 
-			// Set user information in context
-			c.Set("user_id", uint(userIDFloat))
-			c.Set("username", username)
+		// A ticket only works from the page that asked for it
+		if origin := c.GetHeader("Origin"); origin != ticket.Origin {
+			fmt.Printf("WebSocket ticket for origin %q used from %q\n", ticket.Origin, origin)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Ticket was issued to another origin"})
+			c.Abort()
+			return
+		}
 
-			fmt.Printf("WebSocket authenticated user: %s (ID: %v)\n", username, uint(userIDFloat))
-			c.Next()
-		} else {
-			fmt.Println("Invalid token claims")
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		// Reject tickets whose session has been logged out or revoked since
+		if !sessionActive(db, ticket.SessionJTI) {
+			fmt.Println("WebSocket session has been revoked:", ticket.SessionJTI)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
 			c.Abort()
 			return
 		}
+
+		user, ok := loadActiveUser(c, db, ticket.UserID)
+		if !ok {
+			return
+		}
+
+		// Set user information in context
+		c.Set("user_id", user.ID)
+		c.Set("username", user.Username)
+		c.Set("user_role", user.Role)
+		c.Set("session_jti", ticket.SessionJTI)
+
+		fmt.Printf("WebSocket authenticated user: %s (ID: %v)\n", user.Username, user.ID)
+		c.Next()
 	}
-}
\ No newline at end of file
+}
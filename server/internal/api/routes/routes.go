@@ -6,6 +6,7 @@ import (
 
 	"flux/internal/api/handlers"
 	"flux/internal/api/middleware"
+	"flux/internal/auth"
 )
 
 func SetupRoutes(router *gin.Engine, db *gorm.DB) {
@@ -16,53 +17,140 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB) {
 	messageHandler := handlers.NewMessageHandler(db)
 	websocketHandler := handlers.NewWebsocketHandler(db)
 	friendsHandler := handlers.NewFriendsHandler(db)
+	presenceHandler := handlers.NewPresenceHandler(db)
+	conversationHandler := handlers.NewConversationHandler(db)
+	adminHandler := handlers.NewAdminHandler(db)
+	accountHandler := handlers.NewAccountHandler(db)
+
+	// Public keys for verifying Flux tokens
+	router.GET("/.well-known/jwks.json", handlers.GetJWKS)
 
 	// Auth routes
 	authRoutes := router.Group("/auth")
 	{
 		authRoutes.POST("/register", authHandler.Register)
 		authRoutes.POST("/login", authHandler.Login)
+		authRoutes.POST("/refresh", authHandler.Refresh)
+		authRoutes.POST("/password/forgot", authHandler.ForgotPassword)
+		authRoutes.POST("/password/reset", authHandler.ResetPassword)
+		authRoutes.POST("/email/verify", authHandler.VerifyEmail)
+		authRoutes.POST("/mfa/verify", authHandler.VerifyMFA)
+		authRoutes.GET("/oidc/providers", authHandler.ListOIDCProviders)
+		authRoutes.GET("/oidc/:provider/login", authHandler.OIDCLogin)
+		authRoutes.POST("/oidc/:provider/callback", authHandler.OIDCCallback)
+
+		// Account security routes need a logged-in session, personal access tokens can't use them
+		accountRoutes := authRoutes.Group("")
+		accountRoutes.Use(middleware.AuthMiddleware(db), middleware.RequireSession())
+		{
+			accountRoutes.POST("/logout", authHandler.Logout)
+
+			accountRoutes.POST("/mfa/totp/setup", authHandler.SetupTOTP)
+			accountRoutes.POST("/mfa/totp/enable", authHandler.EnableTOTP)
+			accountRoutes.POST("/mfa/totp/disable", authHandler.DisableTOTP)
+			accountRoutes.POST("/mfa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+
+			accountRoutes.GET("/sessions", authHandler.ListSessions)
+			accountRoutes.DELETE("/sessions", authHandler.RevokeAllSessions)
+			accountRoutes.DELETE("/sessions/:id", authHandler.RevokeSession)
+
+			accountRoutes.GET("/tokens", authHandler.ListTokens)
+			accountRoutes.POST("/tokens", authHandler.CreateToken)
+			accountRoutes.DELETE("/tokens/:id", authHandler.RevokeToken)
+		}
+	}
+
+	// Profile and account routes for the logged-in user
+	meRoutes := router.Group("/me")
+	meRoutes.Use(middleware.AuthMiddleware(db), middleware.RequireSession())
+	{
+		meRoutes.GET("", accountHandler.GetMe)
+		meRoutes.PATCH("", accountHandler.UpdateMe)
+		meRoutes.DELETE("", accountHandler.DeleteMe)
+		meRoutes.POST("/avatar", accountHandler.UploadAvatar)
+		meRoutes.POST("/password", accountHandler.ChangePassword)
+		meRoutes.POST("/export", accountHandler.RequestExport)
+		meRoutes.GET("/export/:id", accountHandler.GetExport)
+	}
+
+	// Admin routes, each guarded by the permission it needs
+	adminRoutes := router.Group("/admin")
+	adminRoutes.Use(middleware.AuthMiddleware(db), middleware.RequireSession())
+	{
+		adminRoutes.GET("/users", middleware.RequirePermission(auth.PermUsersRead), adminHandler.ListUsers)
+		adminRoutes.GET("/users/:id", middleware.RequirePermission(auth.PermUsersRead), adminHandler.GetUser)
+		adminRoutes.POST("/users/:id/suspend", middleware.RequirePermission(auth.PermUsersSuspend), adminHandler.SuspendUser)
+		adminRoutes.POST("/users/:id/ban", middleware.RequirePermission(auth.PermUsersBan), adminHandler.BanUser)
+		adminRoutes.POST("/users/:id/unban", middleware.RequirePermission(auth.PermUsersSuspend), adminHandler.UnbanUser)
+		adminRoutes.POST("/users/:id/force-password-reset", middleware.RequirePermission(auth.PermUsersResetPassword), adminHandler.ForcePasswordReset)
+		adminRoutes.PUT("/users/:id/role", middleware.RequirePermission(auth.PermUsersManageRoles), adminHandler.UpdateRole)
+		adminRoutes.DELETE("/posts/:id", middleware.RequirePermission(auth.PermContentDelete), adminHandler.DeletePost)
+		adminRoutes.DELETE("/messages/:id", middleware.RequirePermission(auth.PermContentDelete), adminHandler.DeleteMessage)
+		adminRoutes.GET("/metrics/websocket", middleware.RequirePermission(auth.PermMetricsRead), adminHandler.GetWebsocketMetrics)
 	}
 
 	// Protected routes 
 	protected := router.Group("/")
-	protected.Use(middleware.AuthMiddleware())
+	protected.Use(middleware.AuthMiddleware(db))
 	{
 		postRoutes := protected.Group("/posts")
 		{
-			postRoutes.GET("", postsHandler.GetAllUserPosts)      
-			postRoutes.POST("", postsHandler.CreatePost)          
-			postRoutes.GET("/:id", postsHandler.GetPost)          
-			postRoutes.PUT("/:id", postsHandler.UpdatePost)       
-			postRoutes.DELETE("/:id", postsHandler.DeletePost)    
-			postRoutes.POST("/:id/like", postsHandler.LikePost)   
+			postRoutes.GET("", middleware.RequireScope(auth.ScopePostsRead), postsHandler.GetAllUserPosts)
+			postRoutes.POST("", middleware.RequireScope(auth.ScopePostsWrite), postsHandler.CreatePost)
+			postRoutes.GET("/:id", middleware.RequireScope(auth.ScopePostsRead), postsHandler.GetPost)
+			postRoutes.PUT("/:id", middleware.RequireScope(auth.ScopePostsWrite), postsHandler.UpdatePost)
+			postRoutes.DELETE("/:id", middleware.RequireScope(auth.ScopePostsWrite), postsHandler.DeletePost)
+			postRoutes.POST("/:id/like", middleware.RequireScope(auth.ScopePostsWrite), postsHandler.LikePost)
 		}
 
 		// Feed route separate from posts to avoid conflicts
-		protected.GET("/feed", postsHandler.GetFollowingPosts)
+		protected.GET("/feed", middleware.RequireScope(auth.ScopePostsRead), postsHandler.GetFollowingPosts)
 
 		messageRoutes := protected.Group("/messages")
 		{
-			messageRoutes.POST("", messageHandler.SendMessage)
-			messageRoutes.GET("/conversation", messageHandler.GetConversation)
+			messageRoutes.POST("", middleware.RequireScope(auth.ScopeMessagesWrite), messageHandler.SendMessage)
+			messageRoutes.GET("/conversation", middleware.RequireScope(auth.ScopeMessagesRead), messageHandler.GetConversation)
+			messageRoutes.POST("/read", middleware.RequireScope(auth.ScopeMessagesWrite), messageHandler.MarkRead)
+			messageRoutes.PATCH("/:id", middleware.RequireScope(auth.ScopeMessagesWrite), messageHandler.EditMessage)
+			messageRoutes.DELETE("/:id", middleware.RequireScope(auth.ScopeMessagesWrite), messageHandler.DeleteMessage)
+			messageRoutes.GET("/:id/edits", middleware.RequireScope(auth.ScopeMessagesRead), messageHandler.GetMessageHistory)
+			messageRoutes.POST("/:id/reactions", middleware.RequireScope(auth.ScopeMessagesWrite), messageHandler.AddReaction)
+			messageRoutes.DELETE("/:id/reactions/:emoji", middleware.RequireScope(auth.ScopeMessagesWrite), messageHandler.RemoveReaction)
+		}
+
+		conversationRoutes := protected.Group("/conversations")
+		{
+			conversationRoutes.GET("", middleware.RequireScope(auth.ScopeMessagesRead), conversationHandler.ListConversations)
+			conversationRoutes.POST("", middleware.RequireScope(auth.ScopeMessagesWrite), conversationHandler.CreateGroup)
+			conversationRoutes.GET("/:id", middleware.RequireScope(auth.ScopeMessagesRead), conversationHandler.GetConversationDetails)
+			conversationRoutes.PATCH("/:id", middleware.RequireScope(auth.ScopeMessagesWrite), conversationHandler.UpdateConversation)
+			conversationRoutes.GET("/:id/messages", middleware.RequireScope(auth.ScopeMessagesRead), conversationHandler.ListMessages)
+			conversationRoutes.POST("/:id/read", middleware.RequireScope(auth.ScopeMessagesWrite), conversationHandler.MarkConversationRead)
+			conversationRoutes.POST("/:id/leave", middleware.RequireScope(auth.ScopeMessagesWrite), conversationHandler.LeaveConversation)
+			conversationRoutes.POST("/:id/members", middleware.RequireScope(auth.ScopeMessagesWrite), conversationHandler.AddMembers)
+			conversationRoutes.DELETE("/:id/members/:user_id", middleware.RequireScope(auth.ScopeMessagesWrite), conversationHandler.RemoveMember)
+			conversationRoutes.PUT("/:id/members/:user_id/role", middleware.RequireScope(auth.ScopeMessagesWrite), conversationHandler.UpdateMemberRole)
 		}
 
 		friendsRoutes := protected.Group("/friends")
 		{
-			friendsRoutes.GET("/users", friendsHandler.GetAllUsers)
-			friendsRoutes.POST("/follow", friendsHandler.FollowUser)
-			friendsRoutes.DELETE("/unfollow/:id", friendsHandler.UnfollowUser)
-			friendsRoutes.GET("/followers", friendsHandler.GetFollowers)
-			friendsRoutes.GET("/following", friendsHandler.GetFollowing)
-			friendsRoutes.GET("/search", friendsHandler.SearchUsers)
-			friendsRoutes.GET("/status/:id", friendsHandler.CheckFollowStatus)
+			friendsRoutes.GET("/users", middleware.RequireScope(auth.ScopeFriendsRead), friendsHandler.GetAllUsers)
+			friendsRoutes.POST("/follow", middleware.RequireScope(auth.ScopeFriendsWrite), friendsHandler.FollowUser)
+			friendsRoutes.DELETE("/unfollow/:id", middleware.RequireScope(auth.ScopeFriendsWrite), friendsHandler.UnfollowUser)
+			friendsRoutes.GET("/followers", middleware.RequireScope(auth.ScopeFriendsRead), friendsHandler.GetFollowers)
+			friendsRoutes.GET("/following", middleware.RequireScope(auth.ScopeFriendsRead), friendsHandler.GetFollowing)
+			friendsRoutes.GET("/search", middleware.RequireScope(auth.ScopeFriendsRead), friendsHandler.SearchUsers)
+			friendsRoutes.GET("/status/:id", middleware.RequireScope(auth.ScopeFriendsRead), friendsHandler.CheckFollowStatus)
 		}
 
-		// WebSocket routes with specialized auth middleware
+		protected.GET("/presence", middleware.RequireScope(auth.ScopeFriendsRead), presenceHandler.GetPresence)
+
+		// WebSocket routes: a logged-in session trades its token for a ticket, and
+		// the ticket is what opens the connection
 		websocketRoutes := router.Group("/ws")
-		websocketRoutes.Use(middleware.WSAuthMiddleware())
 		{
-			websocketRoutes.GET("/connect", websocketHandler.HandleConnection)
+			websocketRoutes.POST("/ticket", middleware.AuthMiddleware(db), middleware.RequireSession(), websocketHandler.IssueTicket)
+			websocketRoutes.GET("/connect", middleware.WSAuthMiddleware(db), websocketHandler.HandleConnection)
 		}
 	}
 }
\ No newline at end of file
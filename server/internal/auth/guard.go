@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"flux/internal/models"
+)
+
+// GuardConfig controls how quickly repeated failures are slowed down and locked out
+type GuardConfig struct {
+	// BackoffAfter is the number of failures allowed before delays kick in
+	BackoffAfter int
+	// BaseDelay is the delay after the first failure past BackoffAfter; it doubles with each failure
+	BaseDelay time.Duration
+	// MaxDelay caps the exponential backoff
+	MaxDelay time.Duration
+	// MaxFailures is the number of failures that triggers a lockout
+	MaxFailures int
+	// LockoutDuration is how long a locked key stays locked
+	LockoutDuration time.Duration
+	// Window is how long failures are remembered once attempts stop
+	Window time.Duration
+}
+
+// DefaultGuardConfig is used for logins and second-factor checks
+var DefaultGuardConfig = GuardConfig{
+	BackoffAfter:    3,
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Minute,
+	MaxFailures:     10,
+	LockoutDuration: 15 * time.Minute,
+	Window:          time.Hour,
+}
+
+// RegisterGuardConfig is used for account creation, where every attempt counts
+var RegisterGuardConfig = GuardConfig{
+	BackoffAfter:    5,
+	BaseDelay:       5 * time.Second,
+	MaxDelay:        5 * time.Minute,
+	MaxFailures:     20,
+	LockoutDuration: time.Hour,
+	Window:          24 * time.Hour,
+}
+
+// Guard tracks failed attempts per key in the database and applies exponential
+// backoff followed by a temporary lockout
+type Guard struct {
+	db     *gorm.DB
+	config GuardConfig
+}
+
+// NewGuard creates a guard with the given limits
+func NewGuard(db *gorm.DB, config GuardConfig) *Guard {
+	return &Guard{db: db, config: config}
+}
+
+// LoginUserKey is the throttle key for login attempts against a username
+func LoginUserKey(username string) string {
+	return "login:user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+// LoginIPKey is the throttle key for login attempts from an IP
+func LoginIPKey(ip string) string {
+	return "login:ip:" + ip
+}
+
+// RegisterIPKey is the throttle key for registrations from an IP
+func RegisterIPKey(ip string) string {
+	return "register:ip:" + ip
+}
+
+// MFAUserKey is the throttle key for second-factor attempts against a user
+func MFAUserKey(userID uint) string {
+	return fmt.Sprintf("mfa:user:%d", userID)
+}
+
+// Check reports whether any of the keys is currently locked out or backing off,
+// and how long the caller should wait before trying again
+func (g *Guard) Check(keys ...string) (time.Duration, bool) {
+	var throttles []models.AuthThrottle
+	if err := g.db.Where("key IN ?", keys).Find(&throttles).Error; err != nil {
+		fmt.Printf("Guard - Failed to load throttles: %v\n", err)
+		return 0, false
+	}
+
+	now := time.Now()
+	var wait time.Duration
+	for _, throttle := range throttles {
+		if throttle.LockedUntil != nil && now.Before(*throttle.LockedUntil) {
+			wait = maxDuration(wait, throttle.LockedUntil.Sub(now))
+			continue
+		}
+
+		if now.Sub(throttle.LastFailureAt) > g.config.Window {
+			continue
+		}
+
+		if next := throttle.LastFailureAt.Add(g.delay(throttle.Failures)); now.Before(next) {
+			wait = maxDuration(wait, next.Sub(now))
+		}
+	}
+
+	return wait, wait > 0
+}
+
+// Failure records a failed attempt against every key, locking out keys that
+// reach MaxFailures. username and ip are only used for the audit record.
+func (g *Guard) Failure(username, ip string, keys ...string) {
+	now := time.Now()
+	for _, key := range keys {
+		err := g.db.Transaction(func(tx *gorm.DB) error {
+			var throttle models.AuthThrottle
+			if err := tx.Where(models.AuthThrottle{Key: key}).FirstOrCreate(&throttle).Error; err != nil {
+				return err
+			}
+
+			// Forget old failures once the window has passed
+			if now.Sub(throttle.LastFailureAt) > g.config.Window {
+				throttle.Failures = 0
+			}
+
+			throttle.Failures++
+			throttle.LastFailureAt = now
+
+			if throttle.Failures >= g.config.MaxFailures {
+				lockedUntil := now.Add(g.config.LockoutDuration)
+				if err := tx.Create(&models.LockoutEvent{
+					Key:         key,
+					Username:    username,
+					IP:          ip,
+					Failures:    throttle.Failures,
+					LockedUntil: lockedUntil,
+				}).Error; err != nil {
+					return err
+				}
+
+				fmt.Printf("Guard - Locked out %s until %s after %d failures\n", key, lockedUntil.Format(time.RFC3339), throttle.Failures)
+				throttle.LockedUntil = &lockedUntil
+				throttle.Failures = 0
+			}
+
+			return tx.Save(&throttle).Error
+		})
+		if err != nil {
+			fmt.Printf("Guard - Failed to record failure for %s: %v\n", key, err)
+		}
+	}
+}
+
+// Success clears the counters for every key
+func (g *Guard) Success(keys ...string) {
+	if err := g.db.Unscoped().Where("key IN ?", keys).Delete(&models.AuthThrottle{}).Error; err != nil {
+		fmt.Printf("Guard - Failed to reset throttles: %v\n", err)
+	}
+}
+
+// delay is the backoff required after the given number of failures
+func (g *Guard) delay(failures int) time.Duration {
+	over := failures - g.config.BackoffAfter
+	if over <= 0 {
+		return 0
+	}
+
+	delay := time.Duration(float64(g.config.BaseDelay) * math.Pow(2, float64(over-1)))
+	if delay > g.config.MaxDelay || delay <= 0 {
+		return g.config.MaxDelay
+	}
+	return delay
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSABits is the smallest RSA key the key manager will load
+const minRSABits = 2048
+
+// verificationKey is a public key tokens may be signed with
+type verificationKey struct {
+	kid    string
+	method jwt.SigningMethod
+	public crypto.PublicKey
+}
+
+// KeyManager signs tokens with the active key and verifies them against every
+// loaded key, so old tokens stay valid while keys are rotated
+type KeyManager struct {
+	activeKID string
+	signer    crypto.Signer
+	keys      map[string]verificationKey
+}
+
+// JSONWebKey is a public key as published in the JWKS document
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+var keyManager *KeyManager
+
+// UseKeyManager sets the key manager used to sign and verify tokens
+func UseKeyManager(km *KeyManager) {
+	keyManager = km
+}
+
+// LoadKeyManagerFromEnv loads every *.pem file in JWT_KEYS_DIR. The file name
+// (without extension) is the key's kid. JWT_ACTIVE_KEY_ID names the private key
+// used to sign new tokens; the other files, which may be public keys only, are
+// accepted for verification. RSA keys sign with RS256 and Ed25519 keys with EdDSA.
+func LoadKeyManagerFromEnv() (*KeyManager, error) {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	activeKID := os.Getenv("JWT_ACTIVE_KEY_ID")
+	if dir == "" || activeKID == "" {
+		return nil, errors.New("JWT_KEYS_DIR and JWT_ACTIVE_KEY_ID must be set")
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	km := &KeyManager{keys: make(map[string]verificationKey)}
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key %s: %w", path, err)
+		}
+
+		signer, public, err := parsePEMKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %s: %w", path, err)
+		}
+
+		method, err := signingMethodFor(public)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %s: %w", path, err)
+		}
+
+		km.keys[kid] = verificationKey{kid: kid, method: method, public: public}
+		if kid == activeKID {
+			if signer == nil {
+				return nil, fmt.Errorf("active key %s is a public key, a private key is required to sign", kid)
+			}
+			km.activeKID = kid
+			km.signer = signer
+		}
+	}
+
+	if km.signer == nil {
+		return nil, fmt.Errorf("active key %q not found in %s", activeKID, dir)
+	}
+
+	return km, nil
+}
+
+// parsePEMKey decodes a PKCS#8, PKCS#1 or PKIX PEM block. The signer is nil for public keys.
+func parsePEMKey(data []byte) (crypto.Signer, crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, nil, errors.New("unsupported private key type")
+		}
+		return signer, signer.Public(), nil
+
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, key.Public(), nil
+
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, key, nil
+	}
+
+	return nil, nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+// signingMethodFor picks the JWT algorithm for a public key
+func signingMethodFor(public crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key is %d bits, at least %d are required", key.N.BitLen(), minRSABits)
+		}
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", public)
+}
+
+// Sign signs claims with the active key and sets the kid header
+func (km *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	method := km.keys[km.activeKID].method
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = km.activeKID
+	return token.SignedString(km.signer)
+}
+
+// Keyfunc resolves the verification key named by a token's kid header
+func (km *KeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := km.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	// The algorithm must match the key, never whatever the token claims
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+
+	return key.public, nil
+}
+
+// JWKS returns every verification key as a JSON Web Key, sorted by kid
+func (km *KeyManager) JWKS() []JSONWebKey {
+	keys := make([]JSONWebKey, 0, len(km.keys))
+	for _, key := range km.keys {
+		jwk := JSONWebKey{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+
+		keys = append(keys, jwk)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Kid < keys[j].Kid })
+	return keys
+}
+
+// JWKS returns the public keys of the configured key manager
+func JWKS() []JSONWebKey {
+	if keyManager == nil {
+		return []JSONWebKey{}
+	}
+	return keyManager.JWKS()
+}
@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeKey saves key to dir as <kid>.pem: PKCS#8 for private keys, PKIX for public ones
+func writeKey(t *testing.T, dir, kid string, key interface{}) {
+	t.Helper()
+
+	var block *pem.Block
+	switch key.(type) {
+	case *rsa.PrivateKey, ed25519.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatalf("encode private key: %v", err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	default:
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatalf("encode public key: %v", err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+}
+
+// useKeys loads the keys in dir, signing with active, for the rest of the test
+func useKeys(t *testing.T, dir, active string) {
+	t.Helper()
+
+	t.Setenv("JWT_KEYS_DIR", dir)
+	t.Setenv("JWT_ACTIVE_KEY_ID", active)
+	km, err := LoadKeyManagerFromEnv()
+	if err != nil {
+		t.Fatalf("load keys: %v", err)
+	}
+	UseKeyManager(km)
+	t.Cleanup(func() { UseKeyManager(nil) })
+}
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return key
+}
+
+func newRSAKey(t *testing.T, bits int) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return key
+}
+
+// forge signs access token claims for user 1 with any method, key and kid
+func forge(t *testing.T, method jwt.SigningMethod, key interface{}, kid string) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, Claims{
+		UserID: 1,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "session",
+			Issuer:    issuer(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	})
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+func TestRetiredKeyStillVerifies(t *testing.T) {
+	dir := t.TempDir()
+	old := newEd25519Key(t)
+	writeKey(t, dir, "2024-01", old)
+	useKeys(t, dir, "2024-01")
+
+	issued, err := GenerateAccessToken(1, "alice", "session")
+	if err != nil {
+		t.Fatalf("sign with old key: %v", err)
+	}
+
+	// Rotate: a new active key, and only the public half of the old one is kept
+	writeKey(t, dir, "2024-01", old.Public())
+	writeKey(t, dir, "2024-06", newRSAKey(t, 2048))
+	useKeys(t, dir, "2024-06")
+
+	if _, err := ParseAccessToken(issued); err != nil {
+		t.Fatalf("token signed by the retired key was rejected: %v", err)
+	}
+
+	fresh, err := GenerateAccessToken(1, "alice", "session")
+	if err != nil {
+		t.Fatalf("sign with new key: %v", err)
+	}
+	token, _, err := jwt.NewParser().ParseUnverified(fresh, &Claims{})
+	if err != nil {
+		t.Fatalf("parse new token: %v", err)
+	}
+	if token.Header["kid"] != "2024-06" || token.Method.Alg() != "RS256" {
+		t.Fatalf("new token has kid %v and alg %s, want the new RSA key", token.Header["kid"], token.Method.Alg())
+	}
+	if _, err := ParseAccessToken(fresh); err != nil {
+		t.Fatalf("token signed by the new key was rejected: %v", err)
+	}
+}
+
+func TestTokenAlgorithmMustMatchItsKey(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey := newRSAKey(t, 2048)
+	writeKey(t, dir, "rsa", rsaKey)
+	useKeys(t, dir, "rsa")
+
+	// HMAC keyed with the public key, the classic algorithm confusion attack
+	public, err := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	if err != nil {
+		t.Fatalf("encode public key: %v", err)
+	}
+	tokens := map[string]string{
+		"HS256 with the public key": forge(t, jwt.SigningMethodHS256, public, "rsa"),
+		"EdDSA under the RSA kid":   forge(t, jwt.SigningMethodEdDSA, newEd25519Key(t), "rsa"),
+		"PS256 with the right key":  forge(t, jwt.SigningMethodPS256, rsaKey, "rsa"),
+	}
+	for name, token := range tokens {
+		if _, err := ParseAccessToken(token); err == nil {
+			t.Fatalf("%s was accepted", name)
+		}
+	}
+
+	if _, err := ParseAccessToken(forge(t, jwt.SigningMethodRS256, rsaKey, "rsa")); err != nil {
+		t.Fatalf("RS256 token with the right key was rejected: %v", err)
+	}
+}
+
+func TestUnknownKeyIDIsRejected(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "current", newEd25519Key(t))
+	useKeys(t, dir, "current")
+
+	stranger := newEd25519Key(t)
+	for _, kid := range []string{"other", ""} {
+		if _, err := ParseAccessToken(forge(t, jwt.SigningMethodEdDSA, stranger, kid)); err == nil {
+			t.Fatalf("token with kid %q from an unknown key was accepted", kid)
+		}
+	}
+}
+
+func TestLoadRefusesUnusableKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		key  interface{}
+	}{
+		{"public active key", newEd25519Key(t).Public()},
+		{"short RSA key", newRSAKey(t, 1024)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeKey(t, dir, "active", tt.key)
+			t.Setenv("JWT_KEYS_DIR", dir)
+			t.Setenv("JWT_ACTIVE_KEY_ID", "active")
+
+			if _, err := LoadKeyManagerFromEnv(); err == nil {
+				t.Fatal("key was loaded")
+			}
+		})
+	}
+
+	t.Run("missing active key", func(t *testing.T) {
+		dir := t.TempDir()
+		writeKey(t, dir, "other", newEd25519Key(t))
+		t.Setenv("JWT_KEYS_DIR", dir)
+		t.Setenv("JWT_ACTIVE_KEY_ID", "active")
+
+		if _, err := LoadKeyManagerFromEnv(); err == nil {
+			t.Fatal("keys were loaded without the active one")
+		}
+	})
+}
+
+func TestJWKSPublishesEveryPublicKey(t *testing.T) {
+	dir := t.TempDir()
+	edKey := newEd25519Key(t)
+	rsaKey := newRSAKey(t, 2048)
+	writeKey(t, dir, "a-ed", edKey)
+	writeKey(t, dir, "b-rsa", rsaKey.Public())
+	useKeys(t, dir, "a-ed")
+
+	keys := JWKS()
+	if len(keys) != 2 || keys[0].Kid != "a-ed" || keys[1].Kid != "b-rsa" {
+		t.Fatalf("JWKS = %+v, want both keys sorted by kid", keys)
+	}
+
+	ed := keys[0]
+	x, _ := base64.RawURLEncoding.DecodeString(ed.X)
+	if ed.Kty != "OKP" || ed.Crv != "Ed25519" || ed.Alg != "EdDSA" || ed.Use != "sig" ||
+		!ed25519.PublicKey(x).Equal(edKey.Public()) {
+		t.Fatalf("Ed25519 key published as %+v", ed)
+	}
+
+	rs := keys[1]
+	n, _ := base64.RawURLEncoding.DecodeString(rs.N)
+	e, _ := base64.RawURLEncoding.DecodeString(rs.E)
+	published := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	if rs.Kty != "RSA" || rs.Alg != "RS256" || rs.Use != "sig" || !published.Equal(&rsaKey.PublicKey) {
+		t.Fatalf("RSA key published as %+v", rs)
+	}
+}
@@ -0,0 +1,65 @@
+package auth
+
+// Roles a user can have, from least to most privileged
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Permissions checked by RequirePermission
+const (
+	PermUsersRead          = "users:read"
+	PermUsersSuspend       = "users:suspend"
+	PermUsersBan           = "users:ban"
+	PermUsersResetPassword = "users:reset_password"
+	PermUsersManageRoles   = "users:manage_roles"
+	PermContentDelete      = "content:delete"
+	PermMetricsRead        = "metrics:read"
+)
+
+var rolePermissions = map[string][]string{
+	RoleUser: {},
+	RoleModerator: {
+		PermUsersRead,
+		PermUsersSuspend,
+		PermContentDelete,
+	},
+	RoleAdmin: {
+		PermUsersRead,
+		PermUsersSuspend,
+		PermUsersBan,
+		PermUsersResetPassword,
+		PermUsersManageRoles,
+		PermContentDelete,
+		PermMetricsRead,
+	},
+}
+
+var roleRanks = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// ValidRole reports whether role is a known role
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether the role grants the permission
+func HasPermission(role, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Outranks reports whether role is strictly more privileged than other,
+// which is required to moderate another account
+func Outranks(role, other string) bool {
+	return roleRanks[role] > roleRanks[other]
+}
@@ -0,0 +1,41 @@
+package auth
+
+import "strings"
+
+// PersonalAccessTokenPrefix marks a bearer token as a personal access token rather than a JWT
+const PersonalAccessTokenPrefix = "flux_pat_"
+
+// Scopes a personal access token can be granted
+const (
+	ScopePostsRead     = "posts:read"
+	ScopePostsWrite    = "posts:write"
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+	ScopeFriendsRead   = "friends:read"
+	ScopeFriendsWrite  = "friends:write"
+)
+
+// AllScopes lists every scope in the order they are documented
+var AllScopes = []string{
+	ScopePostsRead,
+	ScopePostsWrite,
+	ScopeMessagesRead,
+	ScopeMessagesWrite,
+	ScopeFriendsRead,
+	ScopeFriendsWrite,
+}
+
+// ValidScope reports whether scope is a known scope
+func ValidScope(scope string) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseScopes splits a stored, space separated scope list
+func ParseScopes(scopes string) []string {
+	return strings.Fields(scopes)
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// AccessTokenTTL is kept short so a leaked access token is only useful briefly
+	AccessTokenTTL = 15 * time.Minute
+
+	// RefreshTokenTTL is how long a refresh token can be exchanged for a new pair
+	RefreshTokenTTL = 30 * 24 * time.Hour
+
+	// MFATokenTTL is how long a user has to enter their second factor after the password
+	MFATokenTTL = 5 * time.Minute
+
+	// WSTicketTTL is how long a WebSocket ticket can be used to open a connection
+	WSTicketTTL = 30 * time.Second
+)
+
+// TokenTypeMFAPending marks a token that only proves the password was correct.
+// It can be exchanged at /auth/mfa/verify and is rejected everywhere else.
+const TokenTypeMFAPending = "mfa_pending"
+
+// Claims represents the claims in an access token. The registered ID (jti)
+// is the JTI of the session the token was issued for.
+type Claims struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	Type     string `json:"typ,omitempty"`
+	jwt.RegisteredClaims
+}
+
+var errKeysNotLoaded = errors.New("token signing keys are not loaded")
+
+// issuer returns the iss claim put in every token, so other services can check where it came from
+func issuer() string {
+	if iss := os.Getenv("JWT_ISSUER"); iss != "" {
+		return iss
+	}
+	return "flux"
+}
+
+// signToken signs claims with the active key
+func signToken(claims Claims) (string, error) {
+	if keyManager == nil {
+		return "", errKeysNotLoaded
+	}
+	claims.Issuer = issuer()
+	return keyManager.Sign(claims)
+}
+
+// GenerateAccessToken creates a short-lived access token bound to a session
+func GenerateAccessToken(userID uint, username, jti string) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		UserID:   userID,
+		Username: username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	return signToken(claims)
+}
+
+// GenerateMFAToken creates a short-lived token for the second step of a login
+func GenerateMFAToken(userID uint, username string) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		UserID:   userID,
+		Username: username,
+		Type:     TokenTypeMFAPending,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(MFATokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	return signToken(claims)
+}
+
+// ParseAccessToken validates an access token and returns its claims
+func ParseAccessToken(tokenString string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Type != "" || claims.UserID == 0 || claims.ID == "" {
+		return nil, errors.New("invalid token claims")
+	}
+
+	return claims, nil
+}
+
+// ParseMFAToken validates an mfa_pending token and returns its claims
+func ParseMFAToken(tokenString string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Type != TokenTypeMFAPending || claims.UserID == 0 {
+		return nil, errors.New("invalid token claims")
+	}
+
+	return claims, nil
+}
+
+// parseToken verifies the signature and expiry of a token
+func parseToken(tokenString string) (*Claims, error) {
+	if keyManager == nil {
+		return nil, errKeysNotLoaded
+	}
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, keyManager.Keyfunc,
+		jwt.WithIssuer(issuer()),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
+
+// GenerateOpaqueToken returns a random URL-safe token and the hash that should be stored
+func GenerateOpaqueToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+
+	raw := base64.RawURLEncoding.EncodeToString(buf)
+	return raw, HashToken(raw), nil
+}
+
+// HashToken hashes an opaque token for storage and lookup
+func HashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateID returns a random identifier suitable for session and device IDs
+func GenerateID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,296 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// sendQueueSize is how many outgoing frames a connection may have waiting. A client
+// that falls this far behind is treated as a slow consumer and disconnected.
+const sendQueueSize = 256
+
+// replayBatchSize is how many logged events are loaded at a time when a client resumes
+const replayBatchSize = 200
+
+// Client is a single WebSocket connection. Everything written to the connection
+// goes through its send queue, which only the client's writer goroutine drains,
+// so a slow connection never holds up anyone else.
+type Client struct {
+	UserID     uint
+	SessionJTI string
+
+	hub    *Hub
+	conn   *websocket.Conn
+	config Config
+	send   chan frame
+	away   bool    // set by the client when it goes idle, guarded by hub.mu
+	resume *uint64 // sequence number to replay the user's events from, if the client asked to resume
+
+	// onReplay, if set, is called with each batch of events once it was replayed
+	onReplay func(events []Envelope)
+
+	mu        sync.Mutex
+	closed    bool
+	closeCode int
+	closeText string
+}
+
+// NewClient wraps an upgraded connection. It isn't routed to until it is registered.
+func (h *Hub) NewClient(conn *websocket.Conn, userID uint, sessionJTI string) *Client {
+	h.mu.RLock()
+	config := h.config
+	h.mu.RUnlock()
+
+	return &Client{
+		UserID:     userID,
+		SessionJTI: sessionJTI,
+		hub:        h,
+		conn:       conn,
+		config:     config,
+		send:       make(chan frame, sendQueueSize),
+		closeCode:  websocket.CloseNormalClosure,
+	}
+}
+
+// Send queues v to be written to this connection as JSON. It returns false if the
+// client is gone or was evicted for falling behind.
+func (c *Client) Send(v interface{}) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		fmt.Printf("WS failed to encode frame for user %d: %v\n", c.UserID, err)
+		return false
+	}
+	return c.enqueue(frame{data: data})
+}
+
+// frame is an encoded envelope waiting to be written, with the sequence number
+// of the event it carries, if any
+type frame struct {
+	seq  uint64
+	data []byte
+}
+
+// ResumeFrom makes the client replay the user's events after seq from the event
+// log before anything live. It must be called before WritePump is started.
+func (c *Client) ResumeFrom(seq uint64) {
+	c.resume = &seq
+}
+
+// OnReplay sets a function to call with each batch of events replayed to a resuming
+// client, once they were written. It must be called before WritePump is started.
+func (c *Client) OnReplay(fn func(events []Envelope)) {
+	c.onReplay = fn
+}
+
+// enqueue adds an encoded frame to the send queue without blocking
+func (c *Client) enqueue(f frame) bool {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return false
+	}
+
+	select {
+	case c.send <- f:
+		c.mu.Unlock()
+		return true
+	default:
+		c.mu.Unlock()
+		fmt.Printf("WS evicting slow consumer: user %d\n", c.UserID)
+		c.hub.Unregister(c, websocket.CloseTryAgainLater, "slow consumer")
+		return false
+	}
+}
+
+// close stops the writer, which sends a close frame with the given code and
+// closes the connection. Only the first call has any effect, and reports true.
+func (c *Client) close(code int, text string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	c.closed = true
+	c.closeCode = code
+	c.closeText = text
+	close(c.send)
+	return true
+}
+
+// WriteNow writes v as JSON straight to the connection. It is only for frames that
+// must go out before WritePump is started.
+func (c *Client) WriteNow(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.write(websocket.TextMessage, data)
+}
+
+// write writes one frame, giving up after the write timeout
+func (c *Client) write(messageType int, data []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// ReadPump reads frames from the connection and passes them to handle until the
+// connection fails, goes idle or is closed, then unregisters the client. Pongs and
+// frames both count as activity; a frame over the size limit closes the connection.
+func (c *Client) ReadPump(handle func(data []byte)) {
+	c.conn.SetReadLimit(c.config.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.config.IdleTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.config.IdleTimeout))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			code, reason := readCloseReason(err)
+			fmt.Printf("WS read ended for user %d (%s): %v\n", c.UserID, reason, err)
+			c.hub.Unregister(c, code, reason)
+			return
+		}
+
+		c.conn.SetReadDeadline(time.Now().Add(c.config.IdleTimeout))
+		handle(data)
+	}
+}
+
+// readCloseReason picks the close code and reason for a connection whose read failed
+func readCloseReason(err error) (int, string) {
+	var closeErr *websocket.CloseError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, websocket.ErrReadLimit):
+		return websocket.CloseMessageTooBig, "message too big"
+	case errors.As(err, &closeErr):
+		// Answer a client's close with its own code, unless it is one that can't be sent
+		switch closeErr.Code {
+		case websocket.CloseNoStatusReceived, websocket.CloseAbnormalClosure, websocket.CloseTLSHandshake:
+			return websocket.CloseNormalClosure, "client closed"
+		}
+		return closeErr.Code, "client closed"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return websocket.CloseGoingAway, "idle timeout"
+	default:
+		// The connection is broken, so there is no one to send a close frame to
+		return websocket.CloseAbnormalClosure, "read failed"
+	}
+}
+
+// WritePump writes queued frames to the connection until the client is closed.
+// It must run in its own goroutine, and it is the only place that writes to conn.
+// A resuming client first gets the events it missed; live events that were queued
+// meanwhile and already replayed are skipped, so none arrive twice.
+func (c *Client) WritePump() {
+	defer c.conn.Close()
+
+	var replayed uint64
+	if c.resume != nil {
+		var err error
+		if replayed, err = c.replay(*c.resume); err != nil {
+			fmt.Printf("WS replay to user %d failed: %v\n", c.UserID, err)
+			c.hub.Unregister(c, websocket.CloseInternalServerErr, "replay failed")
+			c.writeClose()
+			return
+		}
+	}
+
+	ping := time.NewTicker(c.config.PingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case f, ok := <-c.send:
+			if !ok {
+				c.writeClose()
+				return
+			}
+			if f.seq != 0 && f.seq <= replayed {
+				continue
+			}
+			if err := c.write(websocket.TextMessage, f.data); err != nil {
+				fmt.Printf("WS send error to user %d: %v\n", c.UserID, err)
+				c.hub.Unregister(c, websocket.CloseAbnormalClosure, "write failed")
+				return
+			}
+		case <-ping.C:
+			if err := c.write(websocket.PingMessage, nil); err != nil {
+				fmt.Printf("WS ping to user %d failed: %v\n", c.UserID, err)
+				c.hub.Unregister(c, websocket.CloseAbnormalClosure, "ping failed")
+				return
+			}
+		}
+	}
+}
+
+// writeClose sends the close frame the client was closed with. Abnormal closure
+// means the connection already broke, and that code may not be sent anyway.
+func (c *Client) writeClose() {
+	c.mu.Lock()
+	code, text := c.closeCode, c.closeText
+	c.mu.Unlock()
+
+	if code != websocket.CloseAbnormalClosure {
+		c.write(websocket.CloseMessage, websocket.FormatCloseMessage(code, text))
+	}
+}
+
+// replay writes the user's logged events after seq straight to the connection and
+// returns the sequence number of the last one. If some of them are no longer kept
+// the client is told to resync first.
+func (c *Client) replay(seq uint64) (uint64, error) {
+	log := c.hub.eventLog()
+	if log == nil {
+		return seq, nil
+	}
+
+	latest, err := log.Latest(c.UserID)
+	if err != nil {
+		return seq, err
+	}
+	events, err := log.Since(c.UserID, seq, replayBatchSize)
+	if err != nil {
+		return seq, err
+	}
+
+	// Sequence numbers have no holes, so a jump means events were pruned. A cursor
+	// past the latest event didn't come from this server.
+	if seq > latest || (seq < latest && (len(events) == 0 || events[0].Seq != seq+1)) {
+		fmt.Printf("WS user %d resumed from %d but the log has moved on to %d, asking for a resync\n", c.UserID, seq, latest)
+		if err := c.WriteNow(NewEnvelope(EventResync, "", ResyncPayload{Seq: latest})); err != nil {
+			return seq, err
+		}
+		if seq > latest {
+			seq = latest
+		}
+	}
+
+	for {
+		for _, env := range events {
+			if err := c.WriteNow(env); err != nil {
+				return seq, err
+			}
+			seq = env.Seq
+		}
+		if c.onReplay != nil && len(events) > 0 {
+			c.onReplay(events)
+		}
+		if len(events) < replayBatchSize {
+			fmt.Printf("WS replayed events to user %d up to %d\n", c.UserID, seq)
+			return seq, nil
+		}
+
+		if events, err = log.Since(c.UserID, seq, replayBatchSize); err != nil {
+			return seq, err
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package chat
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config holds the keepalive timers and limits applied to every connection
+type Config struct {
+	PingInterval   time.Duration // how often the server pings the client
+	IdleTimeout    time.Duration // how long a connection may go without a frame or pong before it is dropped
+	WriteTimeout   time.Duration // how long writing one frame may take
+	MaxMessageSize int64         // largest frame accepted from a client, in bytes
+}
+
+// DefaultConfig returns the settings used when nothing is configured
+func DefaultConfig() Config {
+	return Config{
+		PingInterval:   30 * time.Second,
+		IdleTimeout:    60 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxMessageSize: 64 << 10,
+	}
+}
+
+// ConfigFromEnv reads WS_PING_INTERVAL, WS_IDLE_TIMEOUT and WS_WRITE_TIMEOUT as
+// durations such as "30s", and WS_MAX_MESSAGE_SIZE in bytes. Anything missing or
+// invalid keeps its default.
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	cfg.PingInterval = envDuration("WS_PING_INTERVAL", cfg.PingInterval)
+	cfg.IdleTimeout = envDuration("WS_IDLE_TIMEOUT", cfg.IdleTimeout)
+	cfg.WriteTimeout = envDuration("WS_WRITE_TIMEOUT", cfg.WriteTimeout)
+
+	if raw := os.Getenv("WS_MAX_MESSAGE_SIZE"); raw != "" {
+		size, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || size <= 0 {
+			fmt.Printf("Warning: Ignoring invalid WS_MAX_MESSAGE_SIZE %q\n", raw)
+		} else {
+			cfg.MaxMessageSize = size
+		}
+	}
+
+	// A ping has to arrive and be answered before the connection counts as idle
+	if cfg.PingInterval >= cfg.IdleTimeout {
+		cfg.PingInterval = cfg.IdleTimeout * 9 / 10
+		fmt.Printf("Warning: WS_PING_INTERVAL must be shorter than WS_IDLE_TIMEOUT, using %s\n", cfg.PingInterval)
+	}
+	return cfg
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		fmt.Printf("Warning: Ignoring invalid %s %q\n", name, raw)
+		return fallback
+	}
+	return d
+}
+
+// SetConfig changes the settings for connections created from now on
+func (h *Hub) SetConfig(cfg Config) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.config = cfg
+}
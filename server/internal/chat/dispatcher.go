@@ -0,0 +1,60 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// HandlerFunc handles one event from a client. Returning an *Error sends it back
+// to the client; any other error is logged and reported as an internal error.
+type HandlerFunc func(c *Client, env Envelope) error
+
+// Dispatcher routes incoming envelopes to the handler registered for their type
+type Dispatcher struct {
+	handlers map[string]HandlerFunc
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{handlers: make(map[string]HandlerFunc)}
+}
+
+// Handle registers the handler for an event type
+func (d *Dispatcher) Handle(eventType string, fn HandlerFunc) {
+	d.handlers[eventType] = fn
+}
+
+// Dispatch decodes a raw frame from the client and runs its handler, replying with
+// an error envelope if the frame is malformed or the handler fails
+func (d *Dispatcher) Dispatch(c *Client, raw []byte) {
+	var env Envelope
+	if err := json.Unmarshal(raw, &env); err != nil || env.Type == "" {
+		c.SendError("", NewError(ErrCodeBadRequest, "Frames must be JSON envelopes with a type"))
+		return
+	}
+
+	if env.V != ProtocolVersion {
+		c.SendError(env.ID, NewError(ErrCodeUnsupportedVersion, fmt.Sprintf("Protocol version %d is required", ProtocolVersion)))
+		return
+	}
+
+	handler, ok := d.handlers[env.Type]
+	if !ok {
+		c.SendError(env.ID, NewError(ErrCodeUnknownEvent, "Unsupported event type: "+env.Type))
+		return
+	}
+
+	if err := handler(c, env); err != nil {
+		if chatErr, ok := err.(*Error); ok {
+			c.SendError(env.ID, chatErr)
+			return
+		}
+		fmt.Printf("WS %s handler failed for user %d: %v\n", env.Type, c.UserID, err)
+		c.SendError(env.ID, NewError(ErrCodeInternal, "Something went wrong, please try again"))
+	}
+}
+
+// SendError sends an error envelope answering the frame with the given ID
+func (c *Client) SendError(id string, err *Error) bool {
+	fmt.Printf("WS error for user %d: %v\n", c.UserID, err)
+	return c.Send(NewEnvelope(EventError, id, err))
+}
@@ -0,0 +1,126 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// EventLog keeps the events sent to each user, numbered per user without gaps, so
+// a client that lost its connection can resume where it left off
+type EventLog interface {
+	// Append stores env for each of the users at once and returns the sequence
+	// number each of them gave it
+	Append(env Envelope, userIDs ...uint) (map[uint]uint64, error)
+	// Since returns up to limit of the user's events after seq, oldest first
+	Since(userID uint, seq uint64, limit int) ([]Envelope, error)
+	// Latest returns the sequence number of the user's newest event
+	Latest(userID uint) (uint64, error)
+}
+
+// publishStripes is how many locks user IDs are spread over when publishing, so
+// publishing to one user doesn't wait on publishing to everyone else
+const publishStripes = 64
+
+// SetEventLog sets where published events are recorded. Without one, events are
+// only delivered live.
+func (h *Hub) SetEventLog(log EventLog) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.log = log
+}
+
+func (h *Hub) eventLog() EventLog {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.log
+}
+
+// Publish records env in the user's event log and queues it, stamped with its
+// sequence number, for every connection the user has open except skip. It returns
+// how many connections accepted it. Ephemeral events such as typing don't need a
+// sequence number and go through SendToUser instead.
+func (h *Hub) Publish(userID uint, skip *Client, env Envelope) int {
+	return h.publish(userID, skip, env, nil)
+}
+
+// PublishMany is Publish to several users, such as the members of a group. The
+// event is logged for all of them in one go.
+func (h *Hub) PublishMany(userIDs []uint, env Envelope) int {
+	seen := make(map[uint]bool, len(userIDs))
+	unique := make([]uint, 0, len(userIDs))
+	for _, id := range userIDs {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	unlock := h.lockUsers(unique)
+	defer unlock()
+
+	seqs := h.logEvent(env, unique)
+	delivered := 0
+	for _, id := range unique {
+		env.Seq = seqs[id]
+		delivered += h.deliver(id, nil, env.Seq, env)
+	}
+	return delivered
+}
+
+// publish is Publish, optionally sending ack to skip with the same sequence number
+// so that connection knows it already has the event
+func (h *Hub) publish(userID uint, skip *Client, env Envelope, ack *Envelope) int {
+	unlock := h.lockUsers([]uint{userID})
+	defer unlock()
+
+	env.Seq = h.logEvent(env, []uint{userID})[userID]
+
+	if ack != nil && skip != nil {
+		ack.Seq = env.Seq
+		// Untagged so it is never skipped as replayed; the answer to a send must arrive
+		if data, err := json.Marshal(ack); err == nil {
+			skip.enqueue(frame{data: data})
+		}
+	}
+
+	return h.deliver(userID, skip, env.Seq, env)
+}
+
+// logEvent appends env to the users' event logs. Users it couldn't be logged for
+// are missing from the result and get the event live only.
+func (h *Hub) logEvent(env Envelope, userIDs []uint) map[uint]uint64 {
+	log := h.eventLog()
+	if log == nil || len(userIDs) == 0 {
+		return nil
+	}
+
+	seqs, err := log.Append(env, userIDs...)
+	if err != nil {
+		fmt.Printf("WS failed to log %s event for %d user(s): %v\n", env.Type, len(userIDs), err)
+	}
+	return seqs
+}
+
+// lockUsers takes the publish locks of the users, always in the same order so two
+// publishes can't wait on each other, and returns the function that releases them
+func (h *Hub) lockUsers(userIDs []uint) func() {
+	var taken [publishStripes]bool
+	for _, id := range userIDs {
+		taken[id%publishStripes] = true
+	}
+
+	for i := range taken {
+		if taken[i] {
+			h.publishMu[i].Lock()
+		}
+	}
+	return func() {
+		for i := len(taken) - 1; i >= 0; i-- {
+			if taken[i] {
+				h.publishMu[i].Unlock()
+			}
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// memoryLog is an EventLog kept in memory
+type memoryLog struct {
+	mu     sync.Mutex
+	seq    map[uint]uint64
+	events map[uint][]Envelope
+}
+
+func newMemoryLog() *memoryLog {
+	return &memoryLog{seq: make(map[uint]uint64), events: make(map[uint][]Envelope)}
+}
+
+func (l *memoryLog) Append(env Envelope, userIDs ...uint) (map[uint]uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seqs := make(map[uint]uint64, len(userIDs))
+	for _, id := range userIDs {
+		l.seq[id]++
+		env.Seq = l.seq[id]
+		l.events[id] = append(l.events[id], env)
+		seqs[id] = env.Seq
+	}
+	return seqs, nil
+}
+
+func (l *memoryLog) Since(userID uint, seq uint64, limit int) ([]Envelope, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var events []Envelope
+	for _, env := range l.events[userID] {
+		if env.Seq > seq && len(events) < limit {
+			events = append(events, env)
+		}
+	}
+	return events, nil
+}
+
+func (l *memoryLog) Latest(userID uint) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.seq[userID], nil
+}
+
+// prune drops the user's events up to seq, as retention does
+func (l *memoryLog) prune(userID uint, seq uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var kept []Envelope
+	for _, env := range l.events[userID] {
+		if env.Seq > seq {
+			kept = append(kept, env)
+		}
+	}
+	l.events[userID] = kept
+}
+
+// resume connects user 1 to the hub over a real socket, resuming from since.
+// beforeWrite runs after the client is registered and before its writer starts.
+func resume(t *testing.T, h *Hub, since uint64, beforeWrite func(c *Client)) *websocket.Conn {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c := h.NewClient(conn, 1, "session")
+		c.ResumeFrom(since)
+		h.Register(c)
+		if beforeWrite != nil {
+			beforeWrite(c)
+		}
+		go c.WritePump()
+		c.ReadPump(func([]byte) {})
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// receive reads the next n envelopes from the connection
+func receive(t *testing.T, conn *websocket.Conn, n int) []Envelope {
+	t.Helper()
+
+	envs := make([]Envelope, 0, n)
+	for len(envs) < n {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var env Envelope
+		if err := conn.ReadJSON(&env); err != nil {
+			t.Fatalf("read frame %d of %d: %v", len(envs)+1, n, err)
+		}
+		envs = append(envs, env)
+	}
+	return envs
+}
+
+// expectSeqs checks the envelopes are events with the given sequence numbers
+func expectSeqs(t *testing.T, envs []Envelope, want ...uint64) {
+	t.Helper()
+
+	got := make([]uint64, len(envs))
+	for i, env := range envs {
+		got[i] = env.Seq
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] || envs[i].Type != EventRead {
+			t.Fatalf("got events %v (%s), want %v", got, envs[i].Type, want)
+		}
+	}
+}
+
+// expectResync checks env tells the client to resync from seq
+func expectResync(t *testing.T, env Envelope, seq uint64) {
+	t.Helper()
+
+	var payload ResyncPayload
+	if env.Type != EventResync || json.Unmarshal(env.Payload, &payload) != nil || payload.Seq != seq {
+		t.Fatalf("got %s %s, want a resync from %d", env.Type, env.Payload, seq)
+	}
+}
+
+func newLoggedHub(events int) (*Hub, *memoryLog) {
+	h := NewHub()
+	log := newMemoryLog()
+	h.SetEventLog(log)
+	for i := 0; i < events; i++ {
+		log.Append(NewEnvelope(EventRead, "", nil), 1)
+	}
+	return h, log
+}
+
+func TestResumeReplaysMissedEventsOnce(t *testing.T) {
+	h, _ := newLoggedHub(3)
+
+	// An event published while the client connects is both logged and queued live;
+	// it must only arrive once
+	conn := resume(t, h, 1, func(*Client) {
+		h.Publish(1, nil, NewEnvelope(EventRead, "", nil))
+	})
+	expectSeqs(t, receive(t, conn, 3), 2, 3, 4)
+
+	h.Publish(1, nil, NewEnvelope(EventRead, "", nil))
+	expectSeqs(t, receive(t, conn, 1), 5)
+}
+
+func TestResumeReportsReplayedEvents(t *testing.T) {
+	h, _ := newLoggedHub(3)
+
+	replayed := make(chan []Envelope, 1)
+	conn := resume(t, h, 1, func(c *Client) {
+		c.OnReplay(func(events []Envelope) { replayed <- events })
+	})
+	expectSeqs(t, receive(t, conn, 2), 2, 3)
+
+	select {
+	case events := <-replayed:
+		expectSeqs(t, events, 2, 3)
+	case <-time.After(2 * time.Second):
+		t.Fatal("replayed events weren't reported")
+	}
+}
+
+func TestResumeAfterPrunedEventsAsksForResync(t *testing.T) {
+	h, log := newLoggedHub(4)
+	log.prune(1, 2)
+
+	conn := resume(t, h, 1, nil)
+	envs := receive(t, conn, 3)
+	expectResync(t, envs[0], 4)
+	expectSeqs(t, envs[1:], 3, 4)
+}
+
+func TestResumeFromUnknownCursorAsksForResync(t *testing.T) {
+	h, _ := newLoggedHub(4)
+
+	conn := resume(t, h, 10, nil)
+	expectResync(t, receive(t, conn, 1)[0], 4)
+
+	// Live events carry on from the log's cursor
+	h.Publish(1, nil, NewEnvelope(EventRead, "", nil))
+	expectSeqs(t, receive(t, conn, 1), 5)
+}
+
+func TestResumeUpToDateReplaysNothing(t *testing.T) {
+	h, _ := newLoggedHub(2)
+
+	conn := resume(t, h, 2, nil)
+	h.Publish(1, nil, NewEnvelope(EventRead, "", nil))
+	expectSeqs(t, receive(t, conn, 1), 3)
+}
@@ -1,50 +1,264 @@
 package chat
 
 import (
+	"encoding/json"
 	"fmt"
+	"sync"
+
 	"flux/internal/models"
 
 	"github.com/gorilla/websocket"
 )
 
-var Clients = make(map[*websocket.Conn]uint) // conn -> userID
-var Broadcast = make(chan models.Message)
+// Hub tracks live connections by user ID and routes frames to them. It is safe
+// for concurrent use.
+type Hub struct {
+	mu         sync.RWMutex
+	users      map[uint]map[*Client]struct{} // userID -> that user's connections
+	onPresence func(userID uint, status string)
+	log        EventLog
+	config     Config
+	closes     closeStats
 
-func HandleMessages() {
-	// Infinite event loop
-	for {
-		msg := <-Broadcast
-		fmt.Printf("Broadcasting message from user %d to user %d\n", msg.SenderID, msg.ReceiverID)
-		
-		for client, userID := range Clients {
-			// Send message to the receiver
-			if userID == msg.ReceiverID {
-				err := client.WriteJSON(msg)
-				if err != nil {
-					fmt.Printf("WS send error to user %d: %v\n", userID, err)
-					client.Close()
-					delete(Clients, client)
-				} else {
-					fmt.Printf("Message delivered to user %d\n", userID)
-				}
-			}
+	// publishMu keeps each user's logged events in sequence order on every
+	// connection. A user's lock is publishMu[userID%publishStripes].
+	publishMu [publishStripes]sync.Mutex
+
+	typingMu sync.Mutex
+	typing   map[typingKey]*typingEntry
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		users:  make(map[uint]map[*Client]struct{}),
+		typing: make(map[typingKey]*typingEntry),
+		config: DefaultConfig(),
+	}
+}
+
+// DefaultHub is the hub used by the WebSocket handler and the package level helpers
+var DefaultHub = NewHub()
+
+// Delivery is a message to fan out to every device of its receiver, or of every
+// member of its group, and to the sender's devices other than the one it was sent from
+type Delivery struct {
+	Message    models.Message
+	Origin     *Client // connection the message came in on, nil if it didn't come over a socket
+	Recipients []uint  // group members; a direct message goes to its ReceiverID
+	AckID      string  // ID of the message.send being answered; Origin gets the ack with the sender's sequence number
+
+	// QuoteWithheldFrom are the recipients who joined the group after the message
+	// this one replies to was sent; they get it with the quote withheld
+	QuoteWithheldFrom []uint
+
+	// OnDelivered, if set, is called once the message was queued on at least one of
+	// the receiver's connections
+	OnDelivered func()
+}
+
+var Broadcast = make(chan Delivery)
+
+// Register starts routing frames for the user to the client
+func (h *Hub) Register(c *Client) {
+	h.mu.Lock()
+	before := h.statusLocked(c.UserID)
+	if h.users[c.UserID] == nil {
+		h.users[c.UserID] = make(map[*Client]struct{})
+	}
+	h.users[c.UserID][c] = struct{}{}
+	after, notify := h.statusLocked(c.UserID), h.onPresence
+	h.mu.Unlock()
+
+	h.presenceChanged(notify, c.UserID, before, after)
+}
+
+// Unregister stops routing to the client and closes it with the given close code,
+// counting the reason in the hub's metrics. Calling it more than once is harmless;
+// only the first code and reason are used.
+func (h *Hub) Unregister(c *Client, code int, reason string) {
+	h.mu.Lock()
+	before := h.statusLocked(c.UserID)
+	if conns, ok := h.users[c.UserID]; ok {
+		delete(conns, c)
+		if len(conns) == 0 {
+			delete(h.users, c.UserID)
 		}
 	}
+	after, notify := h.statusLocked(c.UserID), h.onPresence
+	h.mu.Unlock()
+
+	if c.close(code, reason) {
+		h.closes.record(code, reason)
+	}
+
+	if after == StatusOffline {
+		h.stopAllTyping(c.UserID)
+	}
+	h.presenceChanged(notify, c.UserID, before, after)
 }
 
-func GetConnectedUsers() []uint {
-	users := make([]uint, 0, len(Clients))
-	for _, userID := range Clients {
+// clients returns a snapshot of the user's connections
+func (h *Hub) clients(userID uint) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	clients := make([]*Client, 0, len(h.users[userID]))
+	for c := range h.users[userID] {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// SendToUser queues v for every connection the user has open and returns how many
+// accepted it. It never blocks on a slow connection.
+func (h *Hub) SendToUser(userID uint, v interface{}) int {
+	return h.SendToUserExcept(userID, nil, v)
+}
+
+// SendToUserExcept is SendToUser skipping one connection, usually the one v came from
+func (h *Hub) SendToUserExcept(userID uint, except *Client, v interface{}) int {
+	return h.deliver(userID, except, 0, v)
+}
+
+// deliver encodes v once and queues it, tagged with seq, on the user's connections
+// other than except
+func (h *Hub) deliver(userID uint, except *Client, seq uint64, v interface{}) int {
+	clients := h.clients(userID)
+	if len(clients) == 0 || (len(clients) == 1 && clients[0] == except) {
+		return 0
+	}
+
+	// Encode once for every connection
+	data, err := json.Marshal(v)
+	if err != nil {
+		fmt.Printf("WS failed to encode frame for user %d: %v\n", userID, err)
+		return 0
+	}
+
+	delivered := 0
+	for _, c := range clients {
+		if c != except && c.enqueue(frame{seq: seq, data: data}) {
+			delivered++
+		}
+	}
+	return delivered
+}
+
+// IsUserOnline reports whether the user has at least one open connection
+func (h *Hub) IsUserOnline(userID uint) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.users[userID]) > 0
+}
+
+// ConnectedUsers returns the IDs of every user with an open connection
+func (h *Hub) ConnectedUsers() []uint {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	users := make([]uint, 0, len(h.users))
+	for userID := range h.users {
 		users = append(users, userID)
 	}
 	return users
 }
 
-func IsUserOnline(userID uint) bool {
-	for _, connectedUserID := range Clients {
-		if connectedUserID == userID {
-			return true
+// DisconnectSession closes every connection opened with the given session
+func (h *Hub) DisconnectSession(jti string) {
+	h.mu.RLock()
+	var revoked []*Client
+	for _, conns := range h.users {
+		for c := range conns {
+			if c.SessionJTI == jti {
+				revoked = append(revoked, c)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, c := range revoked {
+		fmt.Printf("Closing WebSocket for revoked session of user %d\n", c.UserID)
+		h.Unregister(c, websocket.ClosePolicyViolation, "session revoked")
+	}
+}
+
+// HandleMessages delivers every message published on Broadcast to all of the
+// receivers' devices, and echoes it to the sender's other devices
+func HandleMessages() {
+	for delivery := range Broadcast {
+		msg := delivery.Message
+
+		// The client_msg_id only means something to the sender's devices
+		echo := msg
+		msg.ClientMsgID = ""
+
+		var ack *Envelope
+		if delivery.Origin != nil {
+			env := NewEnvelope(EventMessageAck, delivery.AckID, MessageAckPayload{ClientMsgID: echo.ClientMsgID, Message: echo})
+			ack = &env
+		}
+
+		if len(delivery.Recipients) > 0 {
+			fmt.Printf("Broadcasting message from user %d to conversation %d\n", msg.SenderID, msg.ConversationID)
+			DefaultHub.StopGroupTyping(msg.SenderID, msg.ConversationID)
+			withheld := make(map[uint]bool, len(delivery.QuoteWithheldFrom))
+			for _, userID := range delivery.QuoteWithheldFrom {
+				withheld[userID] = true
+			}
+			others := make([]uint, 0, len(delivery.Recipients))
+			var latecomers []uint
+			for _, userID := range delivery.Recipients {
+				switch {
+				case userID == msg.SenderID:
+				case withheld[userID] && msg.ReplyTo != nil:
+					latecomers = append(latecomers, userID)
+				default:
+					others = append(others, userID)
+				}
+			}
+			DefaultHub.PublishMany(others, NewEnvelope(EventMessageNew, "", msg))
+			if len(latecomers) > 0 {
+				blind := msg
+				blind.ReplyTo = msg.ReplyTo.Withheld()
+				DefaultHub.PublishMany(latecomers, NewEnvelope(EventMessageNew, "", blind))
+			}
+			DefaultHub.publish(msg.SenderID, delivery.Origin, NewEnvelope(EventMessageNew, "", echo), ack)
+			continue
+		}
+
+		fmt.Printf("Broadcasting message from user %d to user %d\n", msg.SenderID, msg.ReceiverID)
+
+		// Sending a message ends the sender's typing indicator
+		DefaultHub.StopTyping(msg.SenderID, msg.ReceiverID)
+
+		var delivered int
+		if msg.SenderID != msg.ReceiverID {
+			delivered = DefaultHub.Publish(msg.ReceiverID, nil, NewEnvelope(EventMessageNew, "", msg))
+			DefaultHub.publish(msg.SenderID, delivery.Origin, NewEnvelope(EventMessageNew, "", echo), ack)
+		} else {
+			// A note to yourself is one event, acked to the device it came from
+			delivered = DefaultHub.publish(msg.SenderID, delivery.Origin, NewEnvelope(EventMessageNew, "", echo), ack)
+		}
+
+		if delivered > 0 {
+			fmt.Printf("Message delivered to user %d on %d connection(s)\n", msg.ReceiverID, delivered)
+			if delivery.OnDelivered != nil {
+				delivery.OnDelivered()
+			}
 		}
 	}
-	return false
-}
\ No newline at end of file
+}
+
+func GetConnectedUsers() []uint {
+	return DefaultHub.ConnectedUsers()
+}
+
+func IsUserOnline(userID uint) bool {
+	return DefaultHub.IsUserOnline(userID)
+}
+
+// DisconnectSession closes every connection opened with the given session
+func DisconnectSession(jti string) {
+	DefaultHub.DisconnectSession(jti)
+}
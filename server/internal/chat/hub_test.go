@@ -0,0 +1,270 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestClient returns a registered client without a connection. Frames queued
+// for it stay in its send channel, where the test can read them.
+func newTestClient(h *Hub, userID uint, sessionJTI string) *Client {
+	c := h.NewClient(nil, userID, sessionJTI)
+	h.Register(c)
+	return c
+}
+
+// queued takes every frame waiting in the client's send queue
+func queued(c *Client) []frame {
+	var frames []frame
+	for {
+		select {
+		case f, ok := <-c.send:
+			if !ok {
+				return frames
+			}
+			frames = append(frames, f)
+		default:
+			return frames
+		}
+	}
+}
+
+// isClosed reports whether the client was closed by the hub
+func isClosed(c *Client) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+func TestSendToUserFansOutToEveryConnection(t *testing.T) {
+	h := NewHub()
+	phone := newTestClient(h, 1, "a")
+	laptop := newTestClient(h, 1, "b")
+	tablet := newTestClient(h, 1, "c")
+	other := newTestClient(h, 2, "d")
+
+	if n := h.SendToUser(1, NewEnvelope(EventTyping, "", nil)); n != 3 {
+		t.Fatalf("SendToUser reached %d connections, want 3", n)
+	}
+	for _, c := range []*Client{phone, laptop, tablet} {
+		frames := queued(c)
+		if len(frames) != 1 {
+			t.Fatalf("connection got %d frames, want 1", len(frames))
+		}
+		var env Envelope
+		if err := json.Unmarshal(frames[0].data, &env); err != nil || env.Type != EventTyping {
+			t.Fatalf("connection got %s (%v), want a typing envelope", frames[0].data, err)
+		}
+	}
+	if frames := queued(other); len(frames) != 0 {
+		t.Fatalf("another user's connection got %d frames", len(frames))
+	}
+
+	// The connection an event came from can be skipped
+	if n := h.SendToUserExcept(1, laptop, NewEnvelope(EventTyping, "", nil)); n != 2 {
+		t.Fatalf("SendToUserExcept reached %d connections, want 2", n)
+	}
+	if frames := queued(laptop); len(frames) != 0 {
+		t.Fatal("skipped connection got the frame")
+	}
+
+	if n := h.SendToUser(3, NewEnvelope(EventTyping, "", nil)); n != 0 {
+		t.Fatalf("SendToUser to a user without connections reached %d", n)
+	}
+}
+
+func TestSlowConsumerIsEvicted(t *testing.T) {
+	h := NewHub()
+	slow := newTestClient(h, 1, "a")
+	fast := newTestClient(h, 1, "b")
+
+	for i := 0; i < sendQueueSize; i++ {
+		if n := h.SendToUser(1, NewEnvelope(EventTyping, "", nil)); n != 2 {
+			t.Fatalf("frame %d reached %d connections, want 2", i, n)
+		}
+		// The fast connection keeps up
+		queued(fast)
+	}
+
+	// The slow connection's queue is full, so the next frame evicts it
+	if n := h.SendToUser(1, NewEnvelope(EventTyping, "", nil)); n != 1 {
+		t.Fatalf("frame past the queue size reached %d connections, want 1", n)
+	}
+	if !isClosed(slow) {
+		t.Fatal("slow consumer wasn't closed")
+	}
+	if slow.closeCode != websocket.CloseTryAgainLater {
+		t.Fatalf("slow consumer closed with %d, want %d", slow.closeCode, websocket.CloseTryAgainLater)
+	}
+	if slow.Send(NewEnvelope(EventTyping, "", nil)) {
+		t.Fatal("evicted client still accepts frames")
+	}
+
+	if !h.IsUserOnline(1) || len(h.clients(1)) != 1 {
+		t.Fatalf("user has %d connections after eviction, want 1", len(h.clients(1)))
+	}
+	if n := h.Metrics().ClosedByReason["slow consumer"]; n != 1 {
+		t.Fatalf("slow consumer evictions = %d, want 1", n)
+	}
+
+	// Frames queued before the eviction are still written before the close
+	if frames := queued(slow); len(frames) != sendQueueSize {
+		t.Fatalf("slow consumer has %d frames left, want %d", len(frames), sendQueueSize)
+	}
+}
+
+func TestDisconnectSessionClosesOnlyItsConnections(t *testing.T) {
+	h := NewHub()
+	revoked := newTestClient(h, 1, "revoked")
+	revokedToo := newTestClient(h, 1, "revoked")
+	kept := newTestClient(h, 1, "kept")
+
+	h.DisconnectSession("revoked")
+
+	if !isClosed(revoked) || !isClosed(revokedToo) {
+		t.Fatal("connections of the revoked session are still open")
+	}
+	if isClosed(kept) {
+		t.Fatal("connection of another session was closed")
+	}
+	if clients := h.clients(1); len(clients) != 1 || clients[0] != kept {
+		t.Fatalf("user has %d connections left, want only the other session", len(clients))
+	}
+}
+
+func TestUnregisterTwiceIsHarmless(t *testing.T) {
+	h := NewHub()
+	c := newTestClient(h, 1, "a")
+
+	h.Unregister(c, websocket.CloseNormalClosure, "")
+	h.Unregister(c, websocket.CloseGoingAway, "idle timeout")
+
+	if c.closeCode != websocket.CloseNormalClosure {
+		t.Fatalf("close code = %d, want the first one", c.closeCode)
+	}
+	if m := h.Metrics(); m.ClosedByReason["normal"] != 1 || m.ClosedByReason["idle timeout"] != 0 {
+		t.Fatalf("close metrics = %v, want one normal close", m.ClosedByReason)
+	}
+}
+
+// TestHubConcurrentUse hammers the hub from many goroutines; run it with -race
+func TestHubConcurrentUse(t *testing.T) {
+	const (
+		users      = 8
+		goroutines = 16
+		rounds     = 50
+	)
+
+	h := NewHub()
+	var presence sync.Mutex
+	changes := 0
+	h.SetPresenceHandler(func(uint, string) {
+		presence.Lock()
+		changes++
+		presence.Unlock()
+	})
+
+	var drained sync.WaitGroup
+	drain := func(c *Client) {
+		drained.Add(1)
+		go func() {
+			defer drained.Done()
+			for range c.send {
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				userID := uint((g+i)%users + 1)
+				session := fmt.Sprintf("session-%d-%d", g, i)
+
+				c := newTestClient(h, userID, session)
+				drain(c)
+
+				h.SendToUser(userID, NewEnvelope(EventTyping, "", nil))
+				h.SendToUserExcept(uint((g+i+1)%users+1), c, NewEnvelope(EventTyping, "", nil))
+				h.SetAway(c, i%2 == 0)
+				h.IsUserOnline(userID)
+				h.ConnectedUsers()
+				h.Metrics()
+
+				if i%3 == 0 {
+					h.DisconnectSession(session)
+				} else {
+					h.Unregister(c, websocket.CloseNormalClosure, "")
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	drained.Wait()
+
+	if users := h.ConnectedUsers(); len(users) != 0 {
+		t.Fatalf("%d users still connected after every connection closed", len(users))
+	}
+	m := h.Metrics()
+	if m.Connections != 0 {
+		t.Fatalf("%d connections left open", m.Connections)
+	}
+	var closed int64
+	for _, n := range m.ClosedByReason {
+		closed += n
+	}
+	if closed != goroutines*rounds {
+		t.Fatalf("%d closes recorded, want %d", closed, goroutines*rounds)
+	}
+}
+
+// typingFrames decodes the typing payloads queued for the client
+func typingFrames(t *testing.T, c *Client) []TypingPayload {
+	t.Helper()
+
+	var payloads []TypingPayload
+	for _, f := range queued(c) {
+		var env Envelope
+		var payload TypingPayload
+		if err := json.Unmarshal(f.data, &env); err != nil || env.Type != EventTyping {
+			t.Fatalf("unexpected frame %s", f.data)
+		}
+		if err := json.Unmarshal(env.Payload, &payload); err != nil {
+			t.Fatalf("decode typing payload: %v", err)
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
+
+func TestGroupTypingReachesOtherMembers(t *testing.T) {
+	h := NewHub()
+	typist := newTestClient(h, 1, "a")
+	member := newTestClient(h, 2, "b")
+	outsider := newTestClient(h, 3, "c")
+
+	h.StartGroupTyping(1, 10, []uint{1, 2})
+	h.StartGroupTyping(1, 10, []uint{1, 2}) // a refresh isn't forwarded again
+	h.StopGroupTyping(1, 10)
+
+	got := typingFrames(t, member)
+	if len(got) != 2 || got[0].State != TypingStart || got[1].State != TypingStop {
+		t.Fatalf("member got %+v, want a start and a stop", got)
+	}
+	if got[0].UserID != 1 || got[0].ConversationID != 10 {
+		t.Fatalf("member got %+v, want user 1 typing in conversation 10", got[0])
+	}
+	if frames := queued(typist); len(frames) != 0 {
+		t.Fatal("typist was told they are typing")
+	}
+	if frames := queued(outsider); len(frames) != 0 {
+		t.Fatal("someone outside the group was told")
+	}
+}
@@ -0,0 +1,60 @@
+package chat
+
+import (
+	"strconv"
+	"sync"
+)
+
+// closeStats counts why connections were closed
+type closeStats struct {
+	mu       sync.Mutex
+	byReason map[string]int64
+	byCode   map[int]int64
+}
+
+func (s *closeStats) record(code int, reason string) {
+	if reason == "" {
+		reason = "normal"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.byReason == nil {
+		s.byReason = make(map[string]int64)
+		s.byCode = make(map[int]int64)
+	}
+	s.byReason[reason]++
+	s.byCode[code]++
+}
+
+// Metrics is a snapshot of the hub's connection counters
+type Metrics struct {
+	Connections    int              `json:"connections"`
+	Users          int              `json:"users"`
+	ClosedByReason map[string]int64 `json:"closed_by_reason"`
+	ClosedByCode   map[string]int64 `json:"closed_by_code"`
+}
+
+// Metrics returns how many connections are open and why the others were closed
+func (h *Hub) Metrics() Metrics {
+	h.mu.RLock()
+	m := Metrics{Users: len(h.users)}
+	for _, conns := range h.users {
+		m.Connections += len(conns)
+	}
+	h.mu.RUnlock()
+
+	h.closes.mu.Lock()
+	defer h.closes.mu.Unlock()
+
+	m.ClosedByReason = make(map[string]int64, len(h.closes.byReason))
+	for reason, n := range h.closes.byReason {
+		m.ClosedByReason[reason] = n
+	}
+	m.ClosedByCode = make(map[string]int64, len(h.closes.byCode))
+	for code, n := range h.closes.byCode {
+		m.ClosedByCode[strconv.Itoa(code)] = n
+	}
+	return m
+}
@@ -0,0 +1,63 @@
+package chat
+
+// Presence statuses. A user is online if any of their connections is active, away
+// if every connection has reported itself idle, and offline with none open.
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+)
+
+// SetPresenceHandler sets the function called whenever a user's presence status
+// changes. It runs on the goroutine that caused the change, outside the hub's lock.
+func (h *Hub) SetPresenceHandler(fn func(userID uint, status string)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.onPresence = fn
+}
+
+// Status returns the user's current presence status
+func (h *Hub) Status(userID uint) string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.statusLocked(userID)
+}
+
+// statusLocked works out the user's status from their connections. h.mu must be held.
+func (h *Hub) statusLocked(userID uint) string {
+	conns := h.users[userID]
+	if len(conns) == 0 {
+		return StatusOffline
+	}
+	for c := range conns {
+		if !c.away {
+			return StatusOnline
+		}
+	}
+	return StatusAway
+}
+
+// SetAway marks a connection as idle or active again, e.g. when its tab is hidden
+// or shown
+func (h *Hub) SetAway(c *Client, away bool) {
+	h.mu.Lock()
+	if _, ok := h.users[c.UserID][c]; !ok {
+		h.mu.Unlock()
+		return
+	}
+	before := h.statusLocked(c.UserID)
+	c.away = away
+	after, notify := h.statusLocked(c.UserID), h.onPresence
+	h.mu.Unlock()
+
+	h.presenceChanged(notify, c.UserID, before, after)
+}
+
+// presenceChanged calls the presence handler if the status actually changed
+func (h *Hub) presenceChanged(notify func(uint, string), userID uint, before, after string) {
+	if notify != nil && before != after {
+		notify(userID, after)
+	}
+}
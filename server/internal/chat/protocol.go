@@ -0,0 +1,206 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"flux/internal/models"
+)
+
+// ProtocolVersion is the version of the envelope format below. Frames from a
+// client with a different version are rejected.
+const ProtocolVersion = 1
+
+// Event types carried in Envelope.Type
+const (
+	EventConnected      = "connected"         // server -> client, sent once after the socket opens
+	EventMessageSend    = "message.send"      // client -> server, send a direct message
+	EventMessageNew     = "message.new"       // server -> client, a message to show
+	EventMessageAck     = "message.ack"       // server -> client, a message.send was stored
+	EventDelivered      = "message.delivered" // server -> client, messages reached the receiver
+	EventError          = "error"             // server -> client, a frame was rejected
+	EventTyping         = "typing"            // both ways, typing started or stopped
+	EventPresence       = "presence"          // both ways, a user's online status changed
+	EventRead           = "read"
+	EventConversation   = "conversation.updated" // server -> client, a group was created or changed
+	EventResync         = "resync"               // server -> client, some missed events are gone, refetch over REST
+	EventMessageEdited  = "message.edited"       // server -> client, the sender changed a message
+	EventMessageDeleted = "message.deleted"      // server -> client, a message was unsent, or deleted on your devices
+	EventReaction       = "message.reaction"     // server -> client, someone added or removed an emoji on a message
+)
+
+// Envelope wraps every frame sent over the socket in either direction. ID is chosen
+// by the client and echoed on the ack or error that answers the frame. Seq numbers
+// the events kept for replay; a client resumes by reconnecting with the last it saw.
+type Envelope struct {
+	V       int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Seq     uint64          `json:"seq,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// NewEnvelope builds an outgoing envelope with payload encoded as JSON
+func NewEnvelope(eventType, id string, payload interface{}) Envelope {
+	env := Envelope{V: ProtocolVersion, Type: eventType, ID: id}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			fmt.Printf("WS failed to encode %s payload: %v\n", eventType, err)
+		} else {
+			env.Payload = data
+		}
+	}
+	return env
+}
+
+// Error codes sent in error payloads
+const (
+	ErrCodeBadRequest         = "bad_request"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeUnknownEvent       = "unknown_event"
+	ErrCodeInvalidReceiver    = "invalid_receiver"
+	ErrCodeSpoofedSender      = "spoofed_sender"
+	ErrCodeNotMember          = "not_member"
+	ErrCodeInternal           = "internal_error"
+)
+
+// Error is a failure reported back to the client in an error envelope
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// NewError creates an Error to return from an event handler
+func NewError(code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// ConnectedPayload is the payload of the connected event
+type ConnectedPayload struct {
+	UserID  uint   `json:"user_id"`
+	Version int    `json:"version"`
+	Seq     uint64 `json:"seq"` // the user's latest event; reconnect with ?since= this to miss nothing
+}
+
+// MessageEditedPayload is the payload of a message.edited event
+type MessageEditedPayload struct {
+	MessageID      uint      `json:"message_id"`
+	ConversationID uint      `json:"conversation_id"`
+	Content        string    `json:"content"`
+	EditedAt       time.Time `json:"edited_at"`
+}
+
+// MessageDeletedPayload is the payload of a message.deleted event. Without
+// ForEveryone the message was only deleted for the user receiving the event.
+type MessageDeletedPayload struct {
+	MessageID      uint      `json:"message_id"`
+	ConversationID uint      `json:"conversation_id"`
+	ForEveryone    bool      `json:"for_everyone"`
+	DeletedAt      time.Time `json:"deleted_at"`
+}
+
+// Reaction actions carried in ReactionPayload.Action
+const (
+	ReactionAdded   = "added"
+	ReactionRemoved = "removed"
+)
+
+// ReactionPayload is the payload of a message.reaction event
+type ReactionPayload struct {
+	MessageID      uint   `json:"message_id"`
+	ConversationID uint   `json:"conversation_id"`
+	UserID         uint   `json:"user_id"`
+	Emoji          string `json:"emoji"`
+	Action         string `json:"action"`
+}
+
+// ResyncPayload is the payload of a resync event. Seq is where the event log
+// picks up again.
+type ResyncPayload struct {
+	Seq uint64 `json:"seq"`
+}
+
+// MessageSendPayload is the payload of a message.send event. It goes either to
+// ReceiverID or to a conversation the sender is in. SenderID is optional and only
+// checked against the authenticated user.
+type MessageSendPayload struct {
+	SenderID       uint   `json:"sender_id,omitempty"`
+	ReceiverID     uint   `json:"receiver_id,omitempty"`
+	ConversationID uint   `json:"conversation_id,omitempty"`
+	Content        string `json:"content"`
+	ClientMsgID    string `json:"client_msg_id,omitempty"`
+	ReplyToID      uint   `json:"reply_to_id,omitempty"`
+}
+
+// MessageAckPayload is the payload of a message.ack event
+type MessageAckPayload struct {
+	ClientMsgID string         `json:"client_msg_id,omitempty"`
+	Message     models.Message `json:"message"`
+}
+
+// DeliveredPayload is the payload of a message.delivered event, sent to the sender
+type DeliveredPayload struct {
+	ReceiverID  uint      `json:"receiver_id"`
+	MessageIDs  []uint    `json:"message_ids"`
+	DeliveredAt time.Time `json:"delivered_at"`
+}
+
+// ReadPayload is the payload of a read event. From a client it marks every message
+// from UserID, or in the group ConversationID, up to and including UpToID as read.
+// The server sends it to the sender's devices, or the group's members, and the
+// reader's other devices, with ReaderID and ReadAt set.
+type ReadPayload struct {
+	UserID         uint       `json:"user_id,omitempty"`
+	ConversationID uint       `json:"conversation_id,omitempty"`
+	UpToID         uint       `json:"up_to_id"`
+	ReaderID       uint       `json:"reader_id,omitempty"`
+	ReadAt         *time.Time `json:"read_at,omitempty"`
+}
+
+// PresencePayload is the payload of a presence event. A client sends it with just
+// Status, online or away, to report whether it is idle. The server sends it to a
+// user's mutual followers whenever their status changes; LastSeen is set when they
+// go offline unless they hide it.
+type PresencePayload struct {
+	UserID   uint       `json:"user_id,omitempty"`
+	Status   string     `json:"status"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+// TypingPayload is the payload of a typing event. From a client UserID is who they
+// are typing to, or ConversationID the conversation they are typing in; from the
+// server UserID is who is typing, and ConversationID is set when it is in a group.
+// ExpiresIn is how many seconds a start lasts if it isn't refreshed.
+type TypingPayload struct {
+	UserID         uint   `json:"user_id"`
+	ConversationID uint   `json:"conversation_id,omitempty"`
+	State          string `json:"state"`
+	ExpiresIn      int    `json:"expires_in,omitempty"`
+}
+
+// Actions reported in a conversation.updated event
+const (
+	ConversationCreated       = "created"
+	ConversationMembersAdded  = "members_added"
+	ConversationMemberRemoved = "member_removed"
+	ConversationMemberLeft    = "member_left"
+	ConversationRenamed       = "renamed"
+	ConversationRoleChanged   = "role_changed"
+)
+
+// ConversationEventPayload is the payload of a conversation.updated event, sent to
+// every member of a group and to anyone who was just removed from it
+type ConversationEventPayload struct {
+	ConversationID uint   `json:"conversation_id"`
+	Action         string `json:"action"`
+	ActorID        uint   `json:"actor_id"`
+	UserIDs        []uint `json:"user_ids,omitempty"`
+	Name           string `json:"name,omitempty"`
+	Role           string `json:"role,omitempty"`
+}
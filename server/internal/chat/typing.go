@@ -0,0 +1,127 @@
+package chat
+
+import "time"
+
+// TypingTTL is how long a typing indicator lasts unless the typist refreshes it
+// with another start. Clients should resend start every few seconds while typing.
+const TypingTTL = 6 * time.Second
+
+// Typing states
+const (
+	TypingStart = "start"
+	TypingStop  = "stop"
+)
+
+// typingKey identifies an indicator: from typing to one user, or to a group when
+// conversation is set
+type typingKey struct {
+	from, to     uint
+	conversation uint
+}
+
+// typingEntry is one active indicator. Its timer sends the stop if it isn't refreshed.
+type typingEntry struct {
+	timer      *time.Timer
+	recipients []uint // who was told about the start, and gets the stop
+}
+
+// StartTyping tells to's devices that from is typing to them. Only the first start
+// is forwarded; later ones just push back the expiry.
+func (h *Hub) StartTyping(from, to uint) {
+	h.startTyping(typingKey{from: from, to: to}, []uint{to})
+}
+
+// StopTyping clears from's typing indicator for to, if there is one
+func (h *Hub) StopTyping(from, to uint) {
+	h.stopTyping(typingKey{from: from, to: to})
+}
+
+// StartGroupTyping tells the devices of the group's members that from is typing in
+// it. Only the first start is forwarded; later ones just push back the expiry.
+func (h *Hub) StartGroupTyping(from, conversationID uint, members []uint) {
+	recipients := make([]uint, 0, len(members))
+	for _, id := range members {
+		if id != from {
+			recipients = append(recipients, id)
+		}
+	}
+	h.startTyping(typingKey{from: from, conversation: conversationID}, recipients)
+}
+
+// StopGroupTyping clears from's typing indicator in the group, if there is one
+func (h *Hub) StopGroupTyping(from, conversationID uint) {
+	h.stopTyping(typingKey{from: from, conversation: conversationID})
+}
+
+func (h *Hub) startTyping(key typingKey, recipients []uint) {
+	entry := &typingEntry{recipients: recipients}
+
+	h.typingMu.Lock()
+	old, active := h.typing[key]
+	if active {
+		old.timer.Stop()
+	}
+	entry.timer = time.AfterFunc(TypingTTL, func() { h.expireTyping(key, entry) })
+	h.typing[key] = entry
+	h.typingMu.Unlock()
+
+	if !active {
+		h.sendTyping(key, entry, TypingStart)
+	}
+}
+
+func (h *Hub) stopTyping(key typingKey) {
+	h.typingMu.Lock()
+	entry, active := h.typing[key]
+	if active {
+		entry.timer.Stop()
+		delete(h.typing, key)
+	}
+	h.typingMu.Unlock()
+
+	if active {
+		h.sendTyping(key, entry, TypingStop)
+	}
+}
+
+// stopAllTyping clears every indicator the user has running, e.g. once they go offline
+func (h *Hub) stopAllTyping(from uint) {
+	h.typingMu.Lock()
+	var keys []typingKey
+	for key := range h.typing {
+		if key.from == from {
+			keys = append(keys, key)
+		}
+	}
+	h.typingMu.Unlock()
+
+	for _, key := range keys {
+		h.stopTyping(key)
+	}
+}
+
+// expireTyping stops an indicator that wasn't refreshed in time. A newer start
+// replaces the entry, in which case this one is stale and does nothing.
+func (h *Hub) expireTyping(key typingKey, entry *typingEntry) {
+	h.typingMu.Lock()
+	if h.typing[key] != entry {
+		h.typingMu.Unlock()
+		return
+	}
+	delete(h.typing, key)
+	h.typingMu.Unlock()
+
+	h.sendTyping(key, entry, TypingStop)
+}
+
+func (h *Hub) sendTyping(key typingKey, entry *typingEntry, state string) {
+	payload := TypingPayload{UserID: key.from, ConversationID: key.conversation, State: state}
+	if state == TypingStart {
+		payload.ExpiresIn = int(TypingTTL.Seconds())
+	}
+
+	env := NewEnvelope(EventTyping, "", payload)
+	for _, to := range entry.recipients {
+		h.SendToUser(to, env)
+	}
+}
@@ -16,7 +16,8 @@ import (
 )
 
 type CloudinaryService struct {
-	client *cloudinary.Cloudinary
+	client    *cloudinary.Cloudinary
+	cloudName string
 }
 
 // NewCloudinaryService creates a new Cloudinary service instance
@@ -35,7 +36,8 @@ func NewCloudinaryService() (*CloudinaryService, error) {
 	}
 
 	return &CloudinaryService{
-		client: cld,
+		client:    cld,
+		cloudName: cloudName,
 	}, nil
 }
 
@@ -98,6 +100,38 @@ func (cs *CloudinaryService) DeleteImage(imageURL string) error {
 	return nil
 }
 
+// DownloadImage fetches an image stored in our Cloudinary account. URLs pointing
+// anywhere else are refused so user supplied links are never fetched by the server.
+func (cs *CloudinaryService) DownloadImage(imageURL string) ([]byte, error) {
+	prefix := fmt.Sprintf("https://res.cloudinary.com/%s/", cs.cloudName)
+	if !strings.HasPrefix(imageURL, prefix) {
+		return nil, fmt.Errorf("image is not hosted on Cloudinary: %s", imageURL)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(imageURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image from Cloudinary: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download image from Cloudinary: status %d", resp.StatusCode)
+	}
+
+	// Uploads are capped at 10MB, anything bigger isn't one of ours
+	const maxFileSize = 10 * 1024 * 1024 // 10MB
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image from Cloudinary: %w", err)
+	}
+	if len(data) > maxFileSize {
+		return nil, fmt.Errorf("image is larger than 10MB")
+	}
+
+	return data, nil
+}
+
 // isValidImageType checks if the file has a valid image extension
 func isValidImageType(filename string) bool {
 	ext := strings.ToLower(filepath.Ext(filename))
@@ -0,0 +1,292 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"flux/internal/models"
+)
+
+// Profile is the account information included in an export
+type Profile struct {
+	ID             uint      `json:"id"`
+	Username       string    `json:"username"`
+	Email          string    `json:"email"`
+	EmailVerified  bool      `json:"email_verified"`
+	DisplayName    string    `json:"display_name"`
+	Bio            string    `json:"bio"`
+	AvatarURL      string    `json:"avatar_url"`
+	Website        string    `json:"website"`
+	Role           string    `json:"role"`
+	TOTPEnabled    bool      `json:"totp_enabled"`
+	FollowersCount int       `json:"followers_count"`
+	FollowingCount int       `json:"following_count"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Post is a post included in an export. ImageFile is the image's path inside the
+// archive, empty when it couldn't be downloaded.
+type Post struct {
+	ID        uint      `json:"id"`
+	Caption   string    `json:"caption"`
+	ImageURL  string    `json:"image_url,omitempty"`
+	ImageFile string    `json:"image_file,omitempty"`
+	Likes     int       `json:"likes"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Message is a message the user sent or received, directly or in a group
+type Message struct {
+	ID             uint      `json:"id"`
+	ConversationID uint      `json:"conversation_id"`
+	Group          string    `json:"group,omitempty"` // name of the group it was sent in
+	Direction      string    `json:"direction"`       // "sent" or "received"
+	From           string    `json:"from"`
+	To             string    `json:"to,omitempty"` // empty for group messages
+	Content        string    `json:"content"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Connection is one side of a follow relationship
+type Connection struct {
+	UserID   uint      `json:"user_id"`
+	Username string    `json:"username"`
+	Since    time.Time `json:"since"`
+}
+
+// Archive is everything that goes into an export
+type Archive struct {
+	GeneratedAt time.Time    `json:"generated_at"`
+	Profile     Profile      `json:"profile"`
+	Posts       []Post       `json:"posts"`
+	Messages    []Message    `json:"messages"`
+	Followers   []Connection `json:"followers"`
+	Following   []Connection `json:"following"`
+}
+
+// build collects the user's data and writes it to a zip at dest, returning its size
+func (s *Service) build(userID uint, dest string) (int64, error) {
+	archive, err := s.collect(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Write to a temporary name so a half written file is never served
+	tmp := dest + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, err
+	}
+
+	zw := zip.NewWriter(file)
+	err = s.writeArchive(zw, archive)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	info, err := os.Stat(dest)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// collect loads the user's profile, posts, messages and follows
+func (s *Service) collect(userID uint) (*Archive, error) {
+	var user models.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	archive := &Archive{
+		GeneratedAt: time.Now().UTC(),
+		Profile: Profile{
+			ID:             user.ID,
+			Username:       user.Username,
+			Email:          user.Email,
+			EmailVerified:  user.EmailVerified,
+			DisplayName:    user.DisplayName,
+			Bio:            user.Bio,
+			AvatarURL:      user.AvatarURL,
+			Website:        user.Website,
+			Role:           user.Role,
+			TOTPEnabled:    user.TOTPEnabled,
+			FollowersCount: user.FollowersCount,
+			FollowingCount: user.FollowingCount,
+			CreatedAt:      user.CreatedAt,
+		},
+		Posts:     []Post{},
+		Messages:  []Message{},
+		Followers: []Connection{},
+		Following: []Connection{},
+	}
+
+	var posts []models.Post
+	if err := s.db.Where("user_id = ?", userID).Order("created_at asc").Find(&posts).Error; err != nil {
+		return nil, err
+	}
+	for _, post := range posts {
+		archive.Posts = append(archive.Posts, Post{
+			ID:        post.ID,
+			Caption:   post.Caption,
+			ImageURL:  post.ImageURL,
+			Likes:     post.Likes,
+			CreatedAt: post.CreatedAt,
+			UpdatedAt: post.UpdatedAt,
+		})
+	}
+
+	// The other side of a conversation may have deleted their account since
+	withDeleted := func(db *gorm.DB) *gorm.DB { return db.Unscoped() }
+
+	// Group messages count from when the user joined, as they do in the app
+	var messages []models.Message
+	if err := s.db.Preload("Sender", withDeleted).Preload("Receiver", withDeleted).
+		Joins("LEFT JOIN conversation_members ON conversation_members.conversation_id = messages.conversation_id AND conversation_members.user_id = ? AND conversation_members.deleted_at IS NULL", userID).
+		Where("messages.sender_id = ? OR messages.receiver_id = ? OR (conversation_members.id IS NOT NULL AND messages.created_at >= conversation_members.joined_at)", userID, userID).
+		Order("messages.created_at asc").Find(&messages).Error; err != nil {
+		return nil, err
+	}
+
+	var groupIDs []uint
+	for _, message := range messages {
+		if message.ReceiverID == 0 {
+			groupIDs = append(groupIDs, message.ConversationID)
+		}
+	}
+	groupNames := map[uint]string{}
+	if len(groupIDs) > 0 {
+		var groups []models.Conversation
+		if err := s.db.Unscoped().Where("id IN ?", groupIDs).Find(&groups).Error; err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			groupNames[group.ID] = group.Name
+		}
+	}
+
+	for _, message := range messages {
+		direction := "received"
+		if message.SenderID == userID {
+			direction = "sent"
+		}
+		exported := Message{
+			ID:             message.ID,
+			ConversationID: message.ConversationID,
+			Direction:      direction,
+			From:           message.Sender.Username,
+			Content:        message.Content,
+			CreatedAt:      message.CreatedAt,
+		}
+		if message.ReceiverID == 0 {
+			exported.Group = groupNames[message.ConversationID]
+		} else {
+			exported.To = message.Receiver.Username
+		}
+		archive.Messages = append(archive.Messages, exported)
+	}
+
+	var followers []models.Friend
+	if err := s.db.Preload("Follower").Where("following_id = ?", userID).Order("created_at asc").Find(&followers).Error; err != nil {
+		return nil, err
+	}
+	for _, follow := range followers {
+		archive.Followers = append(archive.Followers, Connection{
+			UserID:   follow.FollowerID,
+			Username: follow.Follower.Username,
+			Since:    follow.CreatedAt,
+		})
+	}
+
+	var following []models.Friend
+	if err := s.db.Preload("Following").Where("follower_id = ?", userID).Order("created_at asc").Find(&following).Error; err != nil {
+		return nil, err
+	}
+	for _, follow := range following {
+		archive.Following = append(archive.Following, Connection{
+			UserID:   follow.FollowingID,
+			Username: follow.Following.Username,
+			Since:    follow.CreatedAt,
+		})
+	}
+
+	return archive, nil
+}
+
+// writeArchive writes images, the JSON files and the HTML view into the zip
+func (s *Service) writeArchive(zw *zip.Writer, archive *Archive) error {
+	create := func(name string) (io.Writer, error) {
+		return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: archive.GeneratedAt})
+	}
+
+	// Images first, so the JSON and HTML can point at the files that made it in
+	for i, post := range archive.Posts {
+		if post.ImageURL == "" || s.media == nil {
+			continue
+		}
+
+		data, err := s.media.DownloadImage(post.ImageURL)
+		if err != nil {
+			fmt.Printf("Export - Skipping image for post %d: %v\n", post.ID, err)
+			continue
+		}
+
+		name := fmt.Sprintf("images/post_%d%s", post.ID, strings.ToLower(path.Ext(post.ImageURL)))
+		w, err := create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		archive.Posts[i].ImageFile = name
+	}
+
+	files := map[string]interface{}{
+		"profile.json":  archive.Profile,
+		"posts.json":    archive.Posts,
+		"messages.json": archive.Messages,
+		"friends.json": map[string]interface{}{
+			"followers": archive.Followers,
+			"following": archive.Following,
+		},
+	}
+	for _, name := range []string{"profile.json", "posts.json", "messages.json", "friends.json"} {
+		w, err := create(name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(files[name]); err != nil {
+			return err
+		}
+	}
+
+	w, err := create("index.html")
+	if err != nil {
+		return err
+	}
+	return indexTemplate.Execute(w, archive)
+}
@@ -0,0 +1,71 @@
+package export
+
+import (
+	"testing"
+	"time"
+
+	"flux/internal/models"
+)
+
+func TestCollectIncludesGroupMessagesSinceJoining(t *testing.T) {
+	s := newTestService(t, 1)
+	users := map[string]models.User{}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		user := models.User{Username: name, Email: name + "@example.com"}
+		if err := s.db.Create(&user).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+		users[name] = user
+	}
+	alice, bob, carol := users["alice"], users["bob"], users["carol"]
+
+	direct := models.Conversation{Kind: models.ConversationDirect}
+	group := models.Conversation{Kind: models.ConversationGroup, Name: "Climbing"}
+	other := models.Conversation{Kind: models.ConversationGroup, Name: "Not mine"}
+	for _, conversation := range []*models.Conversation{&direct, &group, &other} {
+		if err := s.db.Create(conversation).Error; err != nil {
+			t.Fatalf("create conversation: %v", err)
+		}
+	}
+
+	joined := time.Now().Add(-time.Hour)
+	members := []models.ConversationMember{
+		{ConversationID: group.ID, UserID: alice.ID, JoinedAt: joined},
+		{ConversationID: group.ID, UserID: bob.ID, JoinedAt: joined.Add(-time.Hour)},
+		{ConversationID: other.ID, UserID: bob.ID, JoinedAt: joined},
+	}
+	if err := s.db.Create(&members).Error; err != nil {
+		t.Fatalf("add members: %v", err)
+	}
+
+	messages := []models.Message{
+		{SenderID: alice.ID, ReceiverID: carol.ID, ConversationID: direct.ID, Content: "direct", CreatedAt: joined.Add(time.Minute)},
+		{SenderID: bob.ID, ConversationID: group.ID, Content: "before alice joined", CreatedAt: joined.Add(-time.Minute)},
+		{SenderID: bob.ID, ConversationID: group.ID, Content: "welcome", CreatedAt: joined.Add(2 * time.Minute)},
+		{SenderID: alice.ID, ConversationID: group.ID, Content: "thanks", CreatedAt: joined.Add(3 * time.Minute)},
+		{SenderID: bob.ID, ConversationID: other.ID, Content: "elsewhere", CreatedAt: joined.Add(4 * time.Minute)},
+	}
+	if err := s.db.Create(&messages).Error; err != nil {
+		t.Fatalf("create messages: %v", err)
+	}
+
+	archive, err := s.collect(alice.ID)
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+
+	want := []Message{
+		{ConversationID: direct.ID, Direction: "sent", From: "alice", To: "carol", Content: "direct"},
+		{ConversationID: group.ID, Group: "Climbing", Direction: "received", From: "bob", Content: "welcome"},
+		{ConversationID: group.ID, Group: "Climbing", Direction: "sent", From: "alice", Content: "thanks"},
+	}
+	if len(archive.Messages) != len(want) {
+		t.Fatalf("exported %d messages %+v, want %d", len(archive.Messages), archive.Messages, len(want))
+	}
+	for i, got := range archive.Messages {
+		got.ID, got.CreatedAt = 0, time.Time{}
+		if got != want[i] {
+			t.Errorf("message %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
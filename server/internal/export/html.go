@@ -0,0 +1,86 @@
+package export
+
+import (
+	"html/template"
+	"time"
+)
+
+// indexTemplate renders a browsable copy of the archive. html/template escapes
+// everything, so user content can't inject markup into the page.
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.Format("2 Jan 2006 15:04 MST") },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Your Flux data - {{.Profile.Username}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 860px; margin: 2rem auto; padding: 0 1rem; color: #1f2937; }
+h1, h2 { color: #0e7490; }
+section { margin-bottom: 2.5rem; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .4rem .6rem; border-bottom: 1px solid #e5e7eb; vertical-align: top; }
+.post { border: 1px solid #e5e7eb; border-radius: 8px; padding: 1rem; margin-bottom: 1rem; }
+.post img { max-width: 100%; border-radius: 4px; }
+.muted { color: #6b7280; font-size: .9em; }
+</style>
+</head>
+<body>
+<h1>Your Flux data</h1>
+<p class="muted">Generated {{date .GeneratedAt}}. The same data is in the JSON files next to this page.</p>
+
+<section>
+<h2>Profile</h2>
+<table>
+<tr><th>Username</th><td>{{.Profile.Username}}</td></tr>
+<tr><th>Display name</th><td>{{.Profile.DisplayName}}</td></tr>
+<tr><th>Email</th><td>{{.Profile.Email}}{{if not .Profile.EmailVerified}} (unverified){{end}}</td></tr>
+<tr><th>Bio</th><td>{{.Profile.Bio}}</td></tr>
+<tr><th>Website</th><td>{{.Profile.Website}}</td></tr>
+<tr><th>Avatar</th><td>{{.Profile.AvatarURL}}</td></tr>
+<tr><th>Role</th><td>{{.Profile.Role}}</td></tr>
+<tr><th>Two-factor authentication</th><td>{{if .Profile.TOTPEnabled}}On{{else}}Off{{end}}</td></tr>
+<tr><th>Followers / following</th><td>{{.Profile.FollowersCount}} / {{.Profile.FollowingCount}}</td></tr>
+<tr><th>Joined</th><td>{{date .Profile.CreatedAt}}</td></tr>
+</table>
+</section>
+
+<section>
+<h2>Posts ({{len .Posts}})</h2>
+{{range .Posts}}
+<div class="post">
+<p>{{.Caption}}</p>
+{{if .ImageFile}}<img src="{{.ImageFile}}" alt="">{{else if .ImageURL}}<p class="muted">Image: {{.ImageURL}}</p>{{end}}
+<p class="muted">{{date .CreatedAt}} &middot; {{.Likes}} likes</p>
+</div>
+{{else}}
+<p class="muted">No posts.</p>
+{{end}}
+</section>
+
+<section>
+<h2>Messages ({{len .Messages}})</h2>
+{{if .Messages}}
+<table>
+<tr><th>When</th><th>From</th><th>To</th><th>Message</th></tr>
+{{range .Messages}}
+<tr><td class="muted">{{date .CreatedAt}}</td><td>{{.From}}</td><td>{{if .Group}}{{.Group}} (group){{else}}{{.To}}{{end}}</td><td>{{.Content}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p class="muted">No messages.</p>
+{{end}}
+</section>
+
+<section>
+<h2>Followers ({{len .Followers}})</h2>
+{{range .Followers}}<div>{{.Username}} <span class="muted">since {{date .Since}}</span></div>{{else}}<p class="muted">No followers.</p>{{end}}
+</section>
+
+<section>
+<h2>Following ({{len .Following}})</h2>
+{{range .Following}}<div>{{.Username}} <span class="muted">since {{date .Since}}</span></div>{{else}}<p class="muted">Not following anyone.</p>{{end}}
+</section>
+</body>
+</html>
+`))
@@ -0,0 +1,195 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+
+	"flux/internal/cloudinary"
+	"flux/internal/models"
+)
+
+const (
+	// TTL is how long a finished export can be downloaded
+	TTL = 7 * 24 * time.Hour
+
+	// Interval is how often a user may request a new export
+	Interval = 24 * time.Hour
+
+	// cleanupInterval is how often expired archives are removed from disk
+	cleanupInterval = time.Hour
+)
+
+// Service builds data exports in the background, one at a time
+type Service struct {
+	db    *gorm.DB
+	media *cloudinary.CloudinaryService
+	dir   string
+	queue chan uint
+}
+
+// NewService creates an export service writing archives to EXPORT_DIR (default "exports").
+// media may be nil, in which case post images are listed by URL but not included.
+func NewService(db *gorm.DB, media *cloudinary.CloudinaryService) *Service {
+	dir := os.Getenv("EXPORT_DIR")
+	if dir == "" {
+		dir = "exports"
+	}
+
+	return &Service{
+		db:    db,
+		media: media,
+		dir:   dir,
+		queue: make(chan uint, 100),
+	}
+}
+
+// Start picks up exports left unfinished by a previous run and starts the worker
+// and the cleanup loop
+func (s *Service) Start() {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		fmt.Printf("Warning: Failed to create export directory %s: %v\n", s.dir, err)
+	}
+
+	// Nothing is building yet, so a running export was cut off by a crash or restart
+	if err := s.db.Model(&models.DataExport{}).Where("status = ?", models.ExportStatusRunning).
+		Update("status", models.ExportStatusPending).Error; err != nil {
+		fmt.Printf("Warning: Failed to reset interrupted exports: %v\n", err)
+	}
+
+	var unfinished []uint
+	s.db.Model(&models.DataExport{}).Where("status = ?", models.ExportStatusPending).
+		Order("id").Pluck("id", &unfinished)
+
+	go s.work()
+	go s.cleanup()
+
+	// There may be more than the queue holds, so feed them in order as it drains
+	if len(unfinished) > 0 {
+		go func() {
+			for _, id := range unfinished {
+				s.queue <- id
+			}
+		}()
+	}
+}
+
+// Enqueue schedules an export to be built and reports whether it was queued. If
+// the queue is full the export is marked failed straight away, which lets the user
+// ask again without waiting a day.
+func (s *Service) Enqueue(exportID uint) bool {
+	select {
+	case s.queue <- exportID:
+		return true
+	default:
+		fmt.Printf("Export - Queue is full, failing export %d\n", exportID)
+		s.db.Model(&models.DataExport{}).Where("id = ? AND status = ?", exportID, models.ExportStatusPending).
+			Updates(map[string]interface{}{
+				"status": models.ExportStatusFailed,
+				"error":  "Too many exports are being built, please try again later",
+			})
+		return false
+	}
+}
+
+func (s *Service) work() {
+	for id := range s.queue {
+		s.run(id)
+	}
+}
+
+// run builds a single export and records the outcome
+func (s *Service) run(exportID uint) {
+	var job models.DataExport
+	if err := s.db.First(&job, exportID).Error; err != nil {
+		fmt.Printf("Export - Export %d not found: %v\n", exportID, err)
+		return
+	}
+
+	// Claim the job so an export queued twice is only built once
+	claim := s.db.Model(&job).Where("status = ?", models.ExportStatusPending).Update("status", models.ExportStatusRunning)
+	if claim.Error != nil {
+		fmt.Printf("Export - Failed to start export %d: %v\n", job.ID, claim.Error)
+		return
+	}
+	if claim.RowsAffected == 0 {
+		fmt.Printf("Export - Export %d is no longer pending, skipping\n", job.ID)
+		return
+	}
+	fmt.Printf("Export - Building export %d for user %d\n", job.ID, job.UserID)
+
+	path := filepath.Join(s.dir, fmt.Sprintf("export_%d_%d.zip", job.UserID, job.ID))
+	size, err := s.build(job.UserID, path)
+	if err != nil {
+		fmt.Printf("Export - Export %d failed: %v\n", job.ID, err)
+		os.Remove(path)
+		s.db.Model(&job).Updates(map[string]interface{}{
+			"status": models.ExportStatusFailed,
+			"error":  "Failed to build export",
+		})
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(TTL)
+	s.db.Model(&job).Updates(map[string]interface{}{
+		"status":       models.ExportStatusReady,
+		"file_path":    path,
+		"size_bytes":   size,
+		"completed_at": now,
+		"expires_at":   expiresAt,
+	})
+	fmt.Printf("Export - Export %d ready (%d bytes)\n", job.ID, size)
+}
+
+// cleanup periodically deletes archives past their expiry
+func (s *Service) cleanup() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		s.removeExpired()
+		<-ticker.C
+	}
+}
+
+func (s *Service) removeExpired() {
+	var expired []models.DataExport
+	if err := s.db.Where("status = ? AND expires_at < ?", models.ExportStatusReady, time.Now()).
+		Find(&expired).Error; err != nil {
+		fmt.Printf("Export - Failed to look up expired exports: %v\n", err)
+		return
+	}
+
+	for _, job := range expired {
+		if err := os.Remove(job.FilePath); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Export - Failed to remove %s: %v\n", job.FilePath, err)
+			continue
+		}
+		s.db.Model(&job).Updates(map[string]interface{}{
+			"status":    models.ExportStatusExpired,
+			"file_path": "",
+		})
+	}
+}
+
+// RemoveForUser deletes every export belonging to a user, files included
+func (s *Service) RemoveForUser(userID uint) error {
+	var jobs []models.DataExport
+	if err := s.db.Where("user_id = ?", userID).Find(&jobs).Error; err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if job.FilePath != "" {
+			if err := os.Remove(job.FilePath); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return s.db.Unscoped().Where("user_id = ?", userID).Delete(&models.DataExport{}).Error
+}
@@ -0,0 +1,106 @@
+package export
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"flux/internal/models"
+)
+
+// newTestService returns an export service on an empty, migrated database that
+// writes archives to a temporary directory. Its worker isn't started.
+func newTestService(t *testing.T, queueSize int) *Service {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Post{}, &models.Message{}, &models.Friend{}, &models.Conversation{}, &models.ConversationMember{}, &models.DataExport{}); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	return &Service{db: db, dir: t.TempDir(), queue: make(chan uint, queueSize)}
+}
+
+// newExport creates a user and an export of theirs with the given status
+func newExport(t *testing.T, s *Service, username, status string) models.DataExport {
+	t.Helper()
+
+	user := models.User{Username: username, Email: username + "@example.com"}
+	if err := s.db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	job := models.DataExport{UserID: user.ID, Status: status}
+	if err := s.db.Create(&job).Error; err != nil {
+		t.Fatalf("create export: %v", err)
+	}
+	return job
+}
+
+func reload(t *testing.T, s *Service, job models.DataExport) models.DataExport {
+	t.Helper()
+
+	if err := s.db.First(&job, job.ID).Error; err != nil {
+		t.Fatalf("reload export: %v", err)
+	}
+	return job
+}
+
+func TestEnqueueFailsExportWhenQueueIsFull(t *testing.T) {
+	s := newTestService(t, 1)
+	first := newExport(t, s, "alice", models.ExportStatusPending)
+	second := newExport(t, s, "bob", models.ExportStatusPending)
+
+	if !s.Enqueue(first.ID) {
+		t.Fatal("first export wasn't queued")
+	}
+	if s.Enqueue(second.ID) {
+		t.Fatal("second export was queued past the queue's size")
+	}
+
+	if got := reload(t, s, first).Status; got != models.ExportStatusPending {
+		t.Fatalf("queued export status = %s, want %s", got, models.ExportStatusPending)
+	}
+	if got := reload(t, s, second).Status; got != models.ExportStatusFailed {
+		t.Fatalf("rejected export status = %s, want %s", got, models.ExportStatusFailed)
+	}
+}
+
+func TestRunBuildsExportOnce(t *testing.T) {
+	s := newTestService(t, 2)
+	job := newExport(t, s, "alice", models.ExportStatusPending)
+
+	s.run(job.ID)
+	built := reload(t, s, job)
+	if built.Status != models.ExportStatusReady || built.FilePath == "" {
+		t.Fatalf("export status = %s with file %q, want a ready archive", built.Status, built.FilePath)
+	}
+
+	// Running it again, as when it was queued twice, leaves the finished export alone
+	s.run(job.ID)
+	if again := reload(t, s, job); again.Status != models.ExportStatusReady || !again.CompletedAt.Equal(*built.CompletedAt) {
+		t.Fatalf("export was built a second time: %+v", again)
+	}
+}
+
+func TestRunSkipsExportClaimedElsewhere(t *testing.T) {
+	s := newTestService(t, 1)
+	job := newExport(t, s, "alice", models.ExportStatusRunning)
+
+	s.run(job.ID)
+	if got := reload(t, s, job); got.Status != models.ExportStatusRunning || got.FilePath != "" {
+		t.Fatalf("export claimed by another run was built: %+v", got)
+	}
+}
@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogMailer writes messages to a file instead of sending them, for development and tests
+type LogMailer struct {
+	path string
+	from string
+	mu   sync.Mutex
+}
+
+// NewLogMailer creates a mailer that appends to path, or prints to stdout when path is empty
+func NewLogMailer(path, from string) *LogMailer {
+	return &LogMailer{path: path, from: from}
+}
+
+// Send records a single message
+func (m *LogMailer) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := fmt.Sprintf("----- %s -----\n%s\n", time.Now().Format(time.RFC3339), buildMessage(m.from, to, subject, body))
+
+	if m.path == "" {
+		fmt.Print(entry)
+		return nil
+	}
+
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open mail log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(entry); err != nil {
+		return fmt.Errorf("failed to write mail log: %w", err)
+	}
+
+	return nil
+}
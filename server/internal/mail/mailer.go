@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+)
+
+// Mailer sends plain-text email
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// NewMailerFromEnv returns an SMTP mailer when SMTP_HOST is set, otherwise a mailer
+// that writes messages to MAIL_LOG_FILE (or stdout when that is unset too)
+func NewMailerFromEnv() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Flux <no-reply@flux.local>"
+	}
+
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return NewLogMailer(os.Getenv("MAIL_LOG_FILE"), from), nil
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "25"
+	}
+
+	mailer := NewSMTPMailer(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from)
+	if mailer.username != "" && mailer.password == "" {
+		return nil, fmt.Errorf("SMTP_USERNAME is set but SMTP_PASSWORD is empty")
+	}
+
+	return mailer, nil
+}
+
+// buildMessage formats an RFC 5322 message with the given headers and body
+func buildMessage(from, to, subject, body string) []byte {
+	return []byte(fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=\"utf-8\"\r\n\r\n%s\r\n",
+		from, to, subject, body,
+	))
+}
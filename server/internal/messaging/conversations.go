@@ -0,0 +1,168 @@
+package messaging
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"flux/internal/models"
+)
+
+// DirectConversation finds the conversation between two users, creating it and its
+// members the first time they talk
+func DirectConversation(tx *gorm.DB, a, b uint) (models.Conversation, error) {
+	key := models.DirectKey(a, b)
+
+	var conversation models.Conversation
+	err := tx.Where("direct_key = ?", key).First(&conversation).Error
+	if err == nil || !errors.Is(err, gorm.ErrRecordNotFound) {
+		return conversation, err
+	}
+
+	conversation = models.Conversation{Kind: models.ConversationDirect, CreatedByID: a, DirectKey: &key}
+	if err := tx.Create(&conversation).Error; err != nil {
+		return conversation, err
+	}
+
+	members := []models.ConversationMember{NewMember(conversation.ID, a, models.MemberRoleMember)}
+	if a != b {
+		members = append(members, NewMember(conversation.ID, b, models.MemberRoleMember))
+	}
+	return conversation, tx.Create(&members).Error
+}
+
+// NewMember builds a membership that starts now
+func NewMember(conversationID, userID uint, role string) models.ConversationMember {
+	return models.ConversationMember{
+		ConversationID: conversationID,
+		UserID:         userID,
+		Role:           role,
+		JoinedAt:       time.Now(),
+	}
+}
+
+// ErrNotMember is returned when someone sends to a conversation they aren't in
+var ErrNotMember = errors.New("not a member of this conversation")
+
+// addressMessage checks the sender belongs to message.ConversationID and returns
+// every member, who the message is delivered to. For a direct conversation it also
+// fills in the receiver, so the message is stored like any other direct message.
+func addressMessage(db *gorm.DB, message *models.Message) ([]uint, error) {
+	var conversation models.Conversation
+	if err := db.First(&conversation, message.ConversationID).Error; err != nil {
+		return nil, ErrNotMember
+	}
+
+	var memberIDs []uint
+	if err := db.Model(&models.ConversationMember{}).Where("conversation_id = ?", conversation.ID).
+		Pluck("user_id", &memberIDs).Error; err != nil {
+		return nil, err
+	}
+
+	isMember := false
+	message.ReceiverID = message.SenderID
+	for _, id := range memberIDs {
+		if id == message.SenderID {
+			isMember = true
+		} else if !conversation.IsGroup() {
+			message.ReceiverID = id
+		}
+	}
+	if !isMember {
+		return nil, ErrNotMember
+	}
+
+	if conversation.IsGroup() {
+		message.ReceiverID = 0
+	}
+	return memberIDs, nil
+}
+
+// saveMessage stores a new message and updates its conversation: the last message
+// moves up, everyone else gets another unread message, and the conversation comes
+// out of the archive unless they muted it. A message with a receiver goes into their
+// direct conversation; one without goes into the group in message.ConversationID.
+func saveMessage(db *gorm.DB, message *models.Message) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var conversation models.Conversation
+		if message.ReceiverID != 0 {
+			direct, err := DirectConversation(tx, message.SenderID, message.ReceiverID)
+			if err != nil {
+				return err
+			}
+			conversation = direct
+		} else if err := tx.First(&conversation, message.ConversationID).Error; err != nil {
+			return err
+		}
+
+		message.ConversationID = conversation.ID
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&conversation).Updates(map[string]interface{}{
+			"last_message_id": message.ID,
+			"last_message_at": message.CreatedAt,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.ConversationMember{}).
+			Where("conversation_id = ? AND user_id = ?", conversation.ID, message.SenderID).
+			Update("archived", false).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.ConversationMember{}).
+			Where("conversation_id = ? AND user_id != ?", conversation.ID, message.SenderID).
+			Updates(map[string]interface{}{
+				"unread_count": gorm.Expr("unread_count + ?", 1),
+				"archived":     gorm.Expr("archived AND muted"),
+			}).Error
+	})
+}
+
+// RefreshConversation recalculates a conversation's last message and every member's
+// unread count from its messages, after messages were removed or marked read
+func RefreshConversation(tx *gorm.DB, conversationID uint) error {
+	var last models.Message
+	err := tx.Where("conversation_id = ?", conversationID).Order("id desc").First(&last).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	updates := map[string]interface{}{"last_message_id": nil, "last_message_at": nil}
+	if err == nil {
+		updates = map[string]interface{}{"last_message_id": last.ID, "last_message_at": last.CreatedAt}
+	}
+	if err := tx.Model(&models.Conversation{}).Where("id = ?", conversationID).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	var conversation models.Conversation
+	if err := tx.Unscoped().First(&conversation, conversationID).Error; err != nil {
+		return err
+	}
+
+	// Direct messages are read one by one, groups by how far each member has read
+	unread := tx.Model(&models.Message{}).Select("COUNT(*)").
+		Where("messages.conversation_id = conversation_members.conversation_id AND messages.receiver_id = conversation_members.user_id AND messages.sender_id != messages.receiver_id AND messages.read_at IS NULL AND messages.unsent_at IS NULL")
+	if conversation.IsGroup() {
+		unread = tx.Model(&models.Message{}).Select("COUNT(*)").
+			Where("messages.conversation_id = conversation_members.conversation_id AND messages.sender_id != conversation_members.user_id AND messages.id > conversation_members.last_read_message_id AND messages.created_at >= conversation_members.joined_at AND messages.unsent_at IS NULL")
+	}
+	return tx.Model(&models.ConversationMember{}).Where("conversation_id = ?", conversationID).
+		Update("unread_count", unread).Error
+}
+
+// DirectPartner returns the other member of a direct conversation, or the user
+// themselves if they are talking to themselves
+func DirectPartner(db *gorm.DB, conversationID, userID uint) (uint, error) {
+	var partner models.ConversationMember
+	err := db.Where("conversation_id = ? AND user_id != ?", conversationID, userID).First(&partner).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return userID, nil
+	}
+	return partner.UserID, err
+}
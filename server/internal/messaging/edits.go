@@ -0,0 +1,292 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"flux/internal/chat"
+	"flux/internal/models"
+)
+
+// DefaultEditWindow is how long after sending a message its sender can edit it,
+// unless MESSAGE_EDIT_WINDOW says otherwise
+const DefaultEditWindow = 15 * time.Minute
+
+// Errors returned when a message can't be edited or deleted
+var (
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrNotSender        = errors.New("only the sender can change a message")
+	ErrEditWindowClosed = errors.New("message can no longer be edited")
+	ErrMessageUnsent    = errors.New("message was deleted")
+)
+
+// editWindowFromEnv reads MESSAGE_EDIT_WINDOW as a duration such as "15m"
+func editWindowFromEnv() time.Duration {
+	raw := os.Getenv("MESSAGE_EDIT_WINDOW")
+	if raw == "" {
+		return DefaultEditWindow
+	}
+
+	window, err := time.ParseDuration(raw)
+	if err != nil || window <= 0 {
+		fmt.Printf("Warning: Ignoring invalid MESSAGE_EDIT_WINDOW %q\n", raw)
+		return DefaultEditWindow
+	}
+	return window
+}
+
+// ownMessage loads a message the user sent
+func (s *Service) ownMessage(userID, messageID uint) (models.Message, error) {
+	var message models.Message
+	err := s.db.First(&message, messageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return message, ErrMessageNotFound
+	}
+	if err != nil {
+		return message, err
+	}
+	if message.SenderID != userID {
+		return message, ErrNotSender
+	}
+	return message, nil
+}
+
+// Edit replaces the content of a message the user sent within the edit window,
+// keeping the old content in its history, and tells every participant's devices
+func (s *Service) Edit(userID, messageID uint, content string) (models.Message, error) {
+	message, err := s.ownMessage(userID, messageID)
+	if err != nil {
+		return message, err
+	}
+	if message.UnsentAt != nil {
+		return message, ErrMessageUnsent
+	}
+	if strings.TrimSpace(content) == "" {
+		return message, ErrEmptyContent
+	}
+	if time.Since(message.CreatedAt) > s.editWindow {
+		return message, ErrEditWindowClosed
+	}
+	if content == message.Content {
+		return message, nil
+	}
+
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&models.MessageEdit{MessageID: message.ID, Content: message.Content}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&message).Updates(map[string]interface{}{"content": content, "edited_at": now}).Error
+	})
+	if err != nil {
+		return message, err
+	}
+
+	s.notifyParticipants(message, chat.NewEnvelope(chat.EventMessageEdited, "", chat.MessageEditedPayload{
+		MessageID:      message.ID,
+		ConversationID: message.ConversationID,
+		Content:        content,
+		EditedAt:       now,
+	}))
+
+	fmt.Printf("Message %d edited by user %d\n", message.ID, userID)
+	return message, nil
+}
+
+// Delete removes a message the user sent. For everyone, its content, history and
+// reactions are wiped and every participant sees it as deleted; otherwise it is only hidden from
+// the sender's own devices.
+func (s *Service) Delete(userID, messageID uint, forEveryone bool) error {
+	message, err := s.ownMessage(userID, messageID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	payload := chat.MessageDeletedPayload{
+		MessageID:      message.ID,
+		ConversationID: message.ConversationID,
+		ForEveryone:    forEveryone,
+		DeletedAt:      now,
+	}
+
+	if !forEveryone {
+		hide := models.MessageHide{MessageID: message.ID, UserID: userID}
+		if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&hide).Error; err != nil {
+			return err
+		}
+		chat.DefaultHub.Publish(userID, nil, chat.NewEnvelope(chat.EventMessageDeleted, "", payload))
+		return nil
+	}
+
+	if message.UnsentAt != nil {
+		return nil
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := wipeMessage(tx, payload); err != nil {
+			return err
+		}
+		if err := tx.Model(&message).Updates(map[string]interface{}{"content": "", "unsent_at": now}).Error; err != nil {
+			return err
+		}
+		// It no longer counts as unread
+		return RefreshConversation(tx, message.ConversationID)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notifyParticipants(message, chat.NewEnvelope(chat.EventMessageDeleted, "", payload))
+
+	fmt.Printf("Message %d deleted for everyone by user %d\n", message.ID, userID)
+	return nil
+}
+
+// Remove deletes any message on behalf of a moderator. Like a sender's delete for
+// everyone, its history and reactions are wiped and every participant sees it as
+// deleted, but the message itself is soft deleted so it leaves every listing.
+func (s *Service) Remove(messageID uint) (models.Message, error) {
+	var message models.Message
+	err := s.db.First(&message, messageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return message, ErrMessageNotFound
+	}
+	if err != nil {
+		return message, err
+	}
+
+	payload := chat.MessageDeletedPayload{
+		MessageID:      message.ID,
+		ConversationID: message.ConversationID,
+		ForEveryone:    true,
+		DeletedAt:      time.Now(),
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := wipeMessage(tx, payload); err != nil {
+			return err
+		}
+		if err := tx.Delete(&message).Error; err != nil {
+			return err
+		}
+		// The inbox may have been showing it as the last or an unread message
+		return RefreshConversation(tx, message.ConversationID)
+	})
+	if err != nil {
+		return message, err
+	}
+
+	s.notifyParticipants(message, chat.NewEnvelope(chat.EventMessageDeleted, "", payload))
+	return message, nil
+}
+
+// wipeMessage drops what is kept alongside a message that would still show its
+// content once it is deleted: its earlier versions, its reactions and the events
+// about it waiting to be replayed. Those events become the deletion instead, and
+// replies that were logged quoting it no longer do.
+func wipeMessage(tx *gorm.DB, deleted chat.MessageDeletedPayload) error {
+	messageID := deleted.MessageID
+	if err := tx.Where("message_id = ?", messageID).Delete(&models.MessageEdit{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("message_id = ?", messageID).Delete(&models.MessageReaction{}).Error; err != nil {
+		return err
+	}
+
+	tombstone, err := json.Marshal(deleted)
+	if err != nil {
+		return err
+	}
+	// A message is logged with its ID first, edits and reactions with message_id first
+	err = tx.Model(&models.UserEvent{}).
+		Where("(type = ? AND payload LIKE ?) OR (type IN ? AND payload LIKE ?)",
+			chat.EventMessageNew, fmt.Sprintf(`{"ID":%d,%%`, messageID),
+			[]string{chat.EventMessageEdited, chat.EventReaction}, fmt.Sprintf(`{"message_id":%d,%%`, messageID)).
+		Updates(map[string]interface{}{"type": chat.EventMessageDeleted, "payload": string(tombstone)}).Error
+	if err != nil {
+		return err
+	}
+
+	var replies []models.UserEvent
+	if err := tx.Where("type = ? AND payload LIKE ?", chat.EventMessageNew, fmt.Sprintf(`%%"reply_to":{"id":%d,%%`, messageID)).
+		Find(&replies).Error; err != nil {
+		return err
+	}
+	for _, event := range replies {
+		var reply models.Message
+		if err := json.Unmarshal([]byte(event.Payload), &reply); err != nil || reply.ReplyTo == nil {
+			continue
+		}
+		reply.ReplyTo.Content = ""
+		reply.ReplyTo.Unsent = true
+		data, err := json.Marshal(reply)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&event).Update("payload", string(data)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WipeMessagesOf wipes every message a user sent or received, for when their
+// account is deleted. Other participants' logged events show each one as deleted
+// and the messages keep no content, even those a moderator had already removed.
+func WipeMessagesOf(tx *gorm.DB, userID uint) error {
+	var messages []models.Message
+	if err := tx.Unscoped().Where("sender_id = ? OR receiver_id = ?", userID, userID).Find(&messages).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, message := range messages {
+		err := wipeMessage(tx, chat.MessageDeletedPayload{
+			MessageID:      message.ID,
+			ConversationID: message.ConversationID,
+			ForEveryone:    true,
+			DeletedAt:      now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Unscoped().Model(&models.Message{}).Where("sender_id = ? OR receiver_id = ?", userID, userID).
+		Updates(map[string]interface{}{"content": "", "unsent_at": gorm.Expr("COALESCE(unsent_at, ?)", now)}).Error
+}
+
+// History returns the earlier versions of a message, oldest first, to anyone in
+// its conversation
+func (s *Service) History(userID, messageID uint) ([]models.MessageEdit, error) {
+	message, err := s.visibleMessage(userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	edits := []models.MessageEdit{}
+	err = s.db.Where("message_id = ?", message.ID).Order("id").Find(&edits).Error
+	return edits, err
+}
+
+// notifyParticipants publishes an event about a message to every device of everyone
+// in its conversation who can see it; group members who joined later aren't told
+func (s *Service) notifyParticipants(message models.Message, env chat.Envelope) {
+	scope := s.db.Model(&models.ConversationMember{}).Where("conversation_id = ?", message.ConversationID)
+	if message.ReceiverID == 0 {
+		scope = scope.Where("joined_at <= ?", message.CreatedAt)
+	}
+
+	var memberIDs []uint
+	scope.Pluck("user_id", &memberIDs)
+
+	chat.DefaultHub.PublishMany(memberIDs, env)
+}
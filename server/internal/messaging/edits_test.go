@@ -0,0 +1,166 @@
+package messaging
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"flux/internal/chat"
+	"flux/internal/models"
+)
+
+func TestRemoveWipesMessageForEveryone(t *testing.T) {
+	s := newTestService(t)
+	alice, bob := newUser(t, s, "alice"), newUser(t, s, "bob")
+
+	message := send(t, s, SendRequest{SenderID: alice.ID, ReceiverID: bob.ID, Content: "first draft"})
+	if _, err := s.Edit(alice.ID, message.ID, "second draft"); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	if _, err := s.React(bob.ID, message.ID, "👍", true); err != nil {
+		t.Fatalf("react: %v", err)
+	}
+
+	if _, err := s.Remove(message.ID); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+
+	if n := count(t, s, &models.Message{}, "id = ?", message.ID); n != 0 {
+		t.Fatal("removed message is still listed")
+	}
+	if n := count(t, s, &models.MessageEdit{}, "message_id = ?", message.ID); n != 0 {
+		t.Fatalf("%d earlier versions were left behind", n)
+	}
+	if n := count(t, s, &models.MessageReaction{}, "message_id = ?", message.ID); n != 0 {
+		t.Fatalf("%d reactions were left behind", n)
+	}
+
+	for _, user := range []models.User{alice, bob} {
+		// The message, its edit and the reaction were replaced by the deletion too
+		deleted := eventsOfType(t, s, user.ID, chat.EventMessageDeleted)
+		if len(deleted) != 4 {
+			t.Fatalf("user %d got %d message.deleted events, want 4", user.ID, len(deleted))
+		}
+		for _, payload := range deleted {
+			if payload["for_everyone"] != true {
+				t.Fatalf("user %d got message.deleted %v, want it for everyone", user.ID, payload)
+			}
+		}
+	}
+
+	if _, err := s.Remove(message.ID); err != ErrMessageNotFound {
+		t.Fatalf("removing again: err = %v, want %v", err, ErrMessageNotFound)
+	}
+}
+
+func TestDeleteForEveryoneScrubsLoggedEvents(t *testing.T) {
+	s := newTestService(t)
+	alice, bob := newUser(t, s, "alice"), newUser(t, s, "bob")
+
+	message := send(t, s, SendRequest{SenderID: alice.ID, ReceiverID: bob.ID, Content: "first draft"})
+	if _, err := s.Edit(alice.ID, message.ID, "second draft"); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	if _, err := s.React(bob.ID, message.ID, "👍", true); err != nil {
+		t.Fatalf("react: %v", err)
+	}
+	reply := send(t, s, SendRequest{SenderID: bob.ID, ReceiverID: alice.ID, Content: "nice", ReplyToID: message.ID})
+
+	if err := s.Delete(alice.ID, message.ID, true); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	for _, user := range []models.User{alice, bob} {
+		for _, e := range events(t, s, user.ID) {
+			if strings.Contains(e.Payload, "draft") {
+				t.Fatalf("user %d can still replay the deleted content in a %s event: %s", user.ID, e.Type, e.Payload)
+			}
+		}
+		// The message, its edit and the reaction each became the deletion, plus the deletion itself
+		if deleted := eventsOfType(t, s, user.ID, chat.EventMessageDeleted); len(deleted) != 4 {
+			t.Fatalf("user %d has %d message.deleted events, want 4", user.ID, len(deleted))
+		}
+
+		var quoted bool
+		for _, payload := range eventsOfType(t, s, user.ID, chat.EventMessageNew) {
+			if payload["ID"] == float64(reply.ID) {
+				quote, _ := payload["reply_to"].(map[string]interface{})
+				quoted = quote["unsent"] == true
+			}
+		}
+		if !quoted {
+			t.Fatalf("user %d's logged reply doesn't show the quoted message as deleted", user.ID)
+		}
+	}
+}
+
+func TestWipeMessagesOfScrubsOtherParticipantsEvents(t *testing.T) {
+	s := newTestService(t)
+	alice, bob, carol := newUser(t, s, "alice"), newUser(t, s, "bob"), newUser(t, s, "carol")
+	group := newGroup(t, s, alice, bob, carol)
+
+	direct := send(t, s, SendRequest{SenderID: alice.ID, ReceiverID: bob.ID, Content: "secret draft"})
+	if _, err := s.Edit(alice.ID, direct.ID, "secret final"); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	grouped := send(t, s, SendRequest{SenderID: alice.ID, ConversationID: group.ID, Content: "secret plan"})
+	if _, err := s.React(carol.ID, grouped.ID, "👍", true); err != nil {
+		t.Fatalf("react: %v", err)
+	}
+	send(t, s, SendRequest{SenderID: carol.ID, ConversationID: group.ID, Content: "agreed", ReplyToID: grouped.ID})
+	removed := send(t, s, SendRequest{SenderID: alice.ID, ConversationID: group.ID, Content: "secret removed"})
+	if _, err := s.Remove(removed.ID); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+
+	if err := WipeMessagesOf(s.db, alice.ID); err != nil {
+		t.Fatalf("wipe: %v", err)
+	}
+
+	for _, user := range []models.User{bob, carol} {
+		for _, e := range events(t, s, user.ID) {
+			if strings.Contains(e.Payload, "secret") {
+				t.Fatalf("user %d can still replay alice's message in a %s event: %s", user.ID, e.Type, e.Payload)
+			}
+		}
+	}
+	// Including the one a moderator had already removed
+	var kept int64
+	s.db.Unscoped().Model(&models.Message{}).Where("sender_id = ? AND content != ''", alice.ID).Count(&kept)
+	if kept != 0 {
+		t.Fatalf("%d of alice's messages kept their content", kept)
+	}
+	if n := count(t, s, &models.MessageEdit{}, "message_id = ?", direct.ID); n != 0 {
+		t.Fatalf("%d edits of alice's message were kept", n)
+	}
+	if n := count(t, s, &models.MessageReaction{}, "message_id = ?", grouped.ID); n != 0 {
+		t.Fatalf("%d reactions to alice's message were kept", n)
+	}
+}
+
+func TestLateJoinerDoesNotSeeEarlierGroupMessages(t *testing.T) {
+	s := newTestService(t)
+	alice, bob, carol := newUser(t, s, "alice"), newUser(t, s, "bob"), newUser(t, s, "carol")
+	group := newGroup(t, s, alice, bob)
+
+	message := send(t, s, SendRequest{SenderID: alice.ID, ConversationID: group.ID, Content: "before carol"})
+	join(t, s, group, carol, message.CreatedAt.Add(time.Second))
+
+	if _, err := s.Edit(alice.ID, message.ID, "still before carol"); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+
+	if edited := eventsOfType(t, s, bob.ID, chat.EventMessageEdited); len(edited) != 1 {
+		t.Fatalf("bob got %d message.edited events, want 1", len(edited))
+	}
+	if logged := events(t, s, carol.ID); len(logged) != 0 {
+		t.Fatalf("carol was told about a message from before they joined: %+v", logged)
+	}
+
+	if _, err := s.History(bob.ID, message.ID); err != nil {
+		t.Fatalf("bob's history: %v", err)
+	}
+	if _, err := s.History(carol.ID, message.ID); err != ErrMessageNotFound {
+		t.Fatalf("carol's history: err = %v, want %v", err, ErrMessageNotFound)
+	}
+}
@@ -0,0 +1,115 @@
+package messaging
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"flux/internal/chat"
+	"flux/internal/models"
+)
+
+// EventRetention is how long events are kept for clients to resume from. A client
+// that was away longer is told to resync.
+const EventRetention = 7 * 24 * time.Hour
+
+// pruneInterval is how often events past their retention are removed
+const pruneInterval = time.Hour
+
+// EventLog stores the events published to each user in the database
+type EventLog struct {
+	db *gorm.DB
+}
+
+func NewEventLog(db *gorm.DB) *EventLog {
+	return &EventLog{db: db}
+}
+
+// Append gives the event each user's next sequence number and stores it, for all
+// of them in one transaction. Users that don't exist are left out.
+func (l *EventLog) Append(env chat.Envelope, userIDs ...uint) (map[uint]uint64, error) {
+	seqs := make(map[uint]uint64, len(userIDs))
+	err := l.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id IN ?", userIDs).
+			UpdateColumn("event_seq", gorm.Expr("event_seq + 1")).Error; err != nil {
+			return err
+		}
+
+		var users []models.User
+		if err := tx.Select("id", "event_seq").Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		events := make([]models.UserEvent, 0, len(users))
+		for _, user := range users {
+			seqs[user.ID] = user.EventSeq
+			events = append(events, models.UserEvent{
+				UserID:  user.ID,
+				Seq:     user.EventSeq,
+				Type:    env.Type,
+				Payload: string(env.Payload),
+			})
+		}
+		return tx.CreateInBatches(&events, 500).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return seqs, nil
+}
+
+// Since returns up to limit of the user's events after seq, oldest first
+func (l *EventLog) Since(userID uint, seq uint64, limit int) ([]chat.Envelope, error) {
+	var events []models.UserEvent
+	if err := l.db.Where("user_id = ? AND seq > ?", userID, seq).
+		Order("seq").Limit(limit).Find(&events).Error; err != nil {
+		return nil, err
+	}
+
+	envelopes := make([]chat.Envelope, 0, len(events))
+	for _, e := range events {
+		env := chat.Envelope{V: chat.ProtocolVersion, Type: e.Type, Seq: e.Seq}
+		if e.Payload != "" {
+			env.Payload = json.RawMessage(e.Payload)
+		}
+		envelopes = append(envelopes, env)
+	}
+	return envelopes, nil
+}
+
+// Latest returns the sequence number of the user's newest event
+func (l *EventLog) Latest(userID uint) (uint64, error) {
+	var user models.User
+	if err := l.db.Select("id", "event_seq").First(&user, userID).Error; err != nil {
+		return 0, err
+	}
+	return user.EventSeq, nil
+}
+
+// Start removes events past their retention now and every hour from then on, for
+// every user whether or not they ever reconnect
+func (l *EventLog) Start() {
+	go l.cleanup()
+}
+
+func (l *EventLog) cleanup() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := l.Prune(); err != nil {
+			fmt.Printf("Failed to prune old events: %v\n", err)
+		}
+		<-ticker.C
+	}
+}
+
+// Prune drops every event that is too old to resume from
+func (l *EventLog) Prune() error {
+	return l.db.Where("created_at < ?", time.Now().Add(-EventRetention)).Delete(&models.UserEvent{}).Error
+}
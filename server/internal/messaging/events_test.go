@@ -0,0 +1,63 @@
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	"flux/internal/chat"
+	"flux/internal/models"
+)
+
+func TestPruneRemovesOldEventsOfEveryUser(t *testing.T) {
+	s := newTestService(t)
+	log := NewEventLog(s.db)
+	alice, bob := newUser(t, s, "alice"), newUser(t, s, "bob")
+
+	for _, user := range []models.User{alice, bob} {
+		for i := 0; i < 2; i++ {
+			if _, err := log.Append(chat.NewEnvelope(chat.EventRead, "", nil), user.ID); err != nil {
+				t.Fatalf("append: %v", err)
+			}
+		}
+	}
+
+	// Each user's first event is past the retention
+	old := time.Now().Add(-EventRetention - time.Minute)
+	if err := s.db.Model(&models.UserEvent{}).Where("seq = 1").Update("created_at", old).Error; err != nil {
+		t.Fatalf("age events: %v", err)
+	}
+
+	if err := log.Prune(); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+
+	for _, user := range []models.User{alice, bob} {
+		left := events(t, s, user.ID)
+		if len(left) != 1 || left[0].Seq != 2 {
+			t.Fatalf("user %d has events %+v left, want only seq 2", user.ID, left)
+		}
+	}
+}
+
+func TestAppendNumbersEachUsersEvents(t *testing.T) {
+	s := newTestService(t)
+	log := NewEventLog(s.db)
+	alice, bob := newUser(t, s, "alice"), newUser(t, s, "bob")
+
+	if _, err := log.Append(chat.NewEnvelope(chat.EventRead, "", nil), alice.ID); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	seqs, err := log.Append(chat.NewEnvelope(chat.EventRead, "", nil), alice.ID, bob.ID, 9999)
+	if err != nil {
+		t.Fatalf("append to several users: %v", err)
+	}
+	if len(seqs) != 2 || seqs[alice.ID] != 2 || seqs[bob.ID] != 1 {
+		t.Fatalf("seqs = %v, want alice at 2 and bob at 1", seqs)
+	}
+
+	for user, want := range map[uint]uint64{alice.ID: 2, bob.ID: 1} {
+		if latest, err := log.Latest(user); err != nil || latest != want {
+			t.Fatalf("user %d latest = %d (%v), want %d", user, latest, err, want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package messaging
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"flux/internal/chat"
+	"flux/internal/models"
+)
+
+func TestMain(m *testing.M) {
+	go chat.HandleMessages()
+	os.Exit(m.Run())
+}
+
+// newTestService opens an empty, migrated database and returns a service on it.
+// Everything published to the hub during the test is logged to that database.
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Message{}, &models.Conversation{}, &models.ConversationMember{}, &models.UserEvent{}, &models.MessageEdit{}, &models.MessageHide{}, &models.MessageReaction{}); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+
+	chat.DefaultHub.SetEventLog(NewEventLog(db))
+	t.Cleanup(func() {
+		chat.DefaultHub.SetEventLog(nil)
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return NewService(db)
+}
+
+// newUser creates a user with the given username
+func newUser(t *testing.T, s *Service, username string) models.User {
+	t.Helper()
+
+	user := models.User{Username: username, Email: username + "@example.com"}
+	if err := s.db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+// newGroup creates a group of the users
+func newGroup(t *testing.T, s *Service, members ...models.User) models.Conversation {
+	t.Helper()
+
+	group := models.Conversation{Kind: models.ConversationGroup, Name: "group", CreatedByID: members[0].ID}
+	if err := s.db.Create(&group).Error; err != nil {
+		t.Fatalf("create group: %v", err)
+	}
+	for _, user := range members {
+		join(t, s, group, user, time.Now())
+	}
+	return group
+}
+
+// join adds the user to the group as if they joined at the given time
+func join(t *testing.T, s *Service, group models.Conversation, user models.User, at time.Time) {
+	t.Helper()
+
+	member := NewMember(group.ID, user.ID, models.MemberRoleMember)
+	member.JoinedAt = at
+	if err := s.db.Create(&member).Error; err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+}
+
+// send sends a message and waits until the hub has published it to the sender, so
+// it is in the event log of everyone it went to
+func send(t *testing.T, s *Service, req SendRequest) models.Message {
+	t.Helper()
+
+	message, _, err := s.Send(req)
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for count(t, s, &models.UserEvent{}, "user_id = ? AND type = ? AND payload LIKE ?",
+		message.SenderID, chat.EventMessageNew, fmt.Sprintf(`{"ID":%d,%%`, message.ID)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("message %d was never published", message.ID)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return message
+}
+
+// events returns the events logged for the user, oldest first
+func events(t *testing.T, s *Service, userID uint) []models.UserEvent {
+	t.Helper()
+
+	var logged []models.UserEvent
+	if err := s.db.Where("user_id = ?", userID).Order("seq").Find(&logged).Error; err != nil {
+		t.Fatalf("load events: %v", err)
+	}
+	return logged
+}
+
+// eventsOfType returns the payloads of the user's logged events of one type
+func eventsOfType(t *testing.T, s *Service, userID uint, eventType string) []map[string]interface{} {
+	t.Helper()
+
+	var payloads []map[string]interface{}
+	for _, e := range events(t, s, userID) {
+		if e.Type != eventType {
+			continue
+		}
+		var payload map[string]interface{}
+		if err := json.Unmarshal([]byte(e.Payload), &payload); err != nil {
+			t.Fatalf("decode %s payload: %v", e.Type, err)
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
+
+// count returns how many rows of model match the condition
+func count(t *testing.T, s *Service, model interface{}, query string, args ...interface{}) int64 {
+	t.Helper()
+
+	var n int64
+	if err := s.db.Model(model).Where(query, args...).Count(&n).Error; err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	return n
+}
@@ -0,0 +1,271 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"flux/internal/chat"
+	"flux/internal/models"
+)
+
+// QuoteLength is how many characters of a message a reply quotes
+const QuoteLength = 100
+
+// Errors returned for replies and reactions that can't be made
+var (
+	ErrInvalidReply = errors.New("reply_to_id must be a message in the same conversation")
+	ErrInvalidEmoji = errors.New("reaction must be a single emoji")
+)
+
+// validEmoji accepts exactly one emoji: a symbol with any variation selectors, skin
+// tones and tags after it, several of those joined by zero width joiners, a flag or
+// a keycap. Plain text and several emoji in a row are rejected.
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > 32 {
+		return false
+	}
+	runes := []rune(emoji)
+
+	// A flag is a pair of regional indicators
+	if isRegionalIndicator(runes[0]) {
+		return len(runes) == 2 && isRegionalIndicator(runes[1])
+	}
+
+	// A keycap is a digit, # or * in an enclosing keycap
+	if strings.ContainsRune("0123456789#*", runes[0]) {
+		rest := runes[1:]
+		if len(rest) > 0 && rest[0] == '\ufe0f' {
+			rest = rest[1:]
+		}
+		return len(rest) == 1 && rest[0] == '\u20e3'
+	}
+
+	expectSymbol := true
+	for _, r := range runes {
+		switch {
+		case expectSymbol:
+			if !unicode.Is(unicode.So, r) || isRegionalIndicator(r) {
+				return false
+			}
+			expectSymbol = false
+		case r == '\u200d': // zero width joiner
+			expectSymbol = true
+		case !isEmojiModifier(r):
+			return false
+		}
+	}
+	return !expectSymbol
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// isEmojiModifier reports whether r changes the look of the symbol before it
+func isEmojiModifier(r rune) bool {
+	return r == '\ufe0e' || r == '\ufe0f' || // variation selectors
+		(r >= 0x1f3fb && r <= 0x1f3ff) || // skin tones
+		(r >= 0xe0020 && r <= 0xe007f) || // tags, as in the flags of England or Scotland
+		unicode.Is(unicode.Me, r)
+}
+
+// Truncate shortens s to at most n characters, marking the cut with an ellipsis
+func Truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
+}
+
+// checkReply makes sure a new message answers a message from its own conversation
+func (s *Service) checkReply(message models.Message) error {
+	var parent models.Message
+	if err := s.db.First(&parent, *message.ReplyToID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidReply
+		}
+		return err
+	}
+
+	// Messages sent by receiver_id only find their conversation when saved
+	sameConversation := message.ConversationID != 0 && parent.ConversationID == message.ConversationID
+	if message.ConversationID == 0 {
+		sameConversation = parent.ReceiverID != 0 &&
+			((parent.SenderID == message.SenderID && parent.ReceiverID == message.ReceiverID) ||
+				(parent.SenderID == message.ReceiverID && parent.ReceiverID == message.SenderID))
+	}
+	if !sameConversation || parent.UnsentAt != nil {
+		return ErrInvalidReply
+	}
+
+	// Nor can a group member quote what was said before they joined
+	if err := s.canSee(message.SenderID, parent); err != nil {
+		if errors.Is(err, ErrMessageNotFound) {
+			return ErrInvalidReply
+		}
+		return err
+	}
+	return nil
+}
+
+// LoadReplies fills in the quoted snippet of every message that is a reply, as seen
+// by a group member who joined at joinedAt: messages from before then are withheld.
+// The zero time shows every quote, for viewers who can see the whole conversation.
+func LoadReplies(db *gorm.DB, messages []models.Message, joinedAt time.Time) error {
+	parentIDs := []uint{}
+	for _, m := range messages {
+		if m.ReplyToID != nil {
+			parentIDs = append(parentIDs, *m.ReplyToID)
+		}
+	}
+	if len(parentIDs) == 0 {
+		return nil
+	}
+
+	var parents []models.Message
+	if err := db.Where("id IN ?", parentIDs).Find(&parents).Error; err != nil {
+		return err
+	}
+	quotes := make(map[uint]*models.MessageQuote, len(parents))
+	for _, p := range parents {
+		quote := models.MessageQuote{
+			ID:       p.ID,
+			SenderID: p.SenderID,
+			Content:  Truncate(p.Content, QuoteLength),
+			Unsent:   p.UnsentAt != nil,
+		}
+		if p.CreatedAt.Before(joinedAt) {
+			quotes[p.ID] = quote.Withheld()
+		} else {
+			quotes[p.ID] = &quote
+		}
+	}
+
+	// A parent removed by a moderator leaves the reply without a quote
+	for i := range messages {
+		if messages[i].ReplyToID != nil {
+			messages[i].ReplyTo = quotes[*messages[i].ReplyToID]
+		}
+	}
+	return nil
+}
+
+// LoadReactions returns the reactions on each of the messages, grouped by emoji in
+// the order they were first used
+func LoadReactions(db *gorm.DB, messageIDs []uint) (map[uint][]models.ReactionSummary, error) {
+	summaries := make(map[uint][]models.ReactionSummary)
+	if len(messageIDs) == 0 {
+		return summaries, nil
+	}
+
+	var reactions []models.MessageReaction
+	if err := db.Where("message_id IN ?", messageIDs).Order("id").Find(&reactions).Error; err != nil {
+		return nil, err
+	}
+
+	for _, r := range reactions {
+		list := summaries[r.MessageID]
+		found := false
+		for i := range list {
+			if list[i].Emoji == r.Emoji {
+				list[i].Count++
+				list[i].UserIDs = append(list[i].UserIDs, r.UserID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, models.ReactionSummary{Emoji: r.Emoji, Count: 1, UserIDs: []uint{r.UserID}})
+		}
+		summaries[r.MessageID] = list
+	}
+	return summaries, nil
+}
+
+// visibleMessage loads a message the user can see because they are in its conversation
+func (s *Service) visibleMessage(userID, messageID uint) (models.Message, error) {
+	var message models.Message
+	err := s.db.First(&message, messageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return message, ErrMessageNotFound
+	}
+	if err != nil {
+		return message, err
+	}
+	return message, s.canSee(userID, message)
+}
+
+// canSee returns ErrMessageNotFound unless the user is in the message's conversation.
+// Group members only see what was sent since they joined.
+func (s *Service) canSee(userID uint, message models.Message) error {
+	var member models.ConversationMember
+	err := s.db.Where("conversation_id = ? AND user_id = ?", message.ConversationID, userID).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if message.SenderID != userID && message.ReceiverID != userID {
+			return ErrMessageNotFound
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if message.ReceiverID == 0 && message.CreatedAt.Before(member.JoinedAt) {
+		return ErrMessageNotFound
+	}
+	return nil
+}
+
+// React adds or removes the user's emoji on a message and tells every participant's
+// devices. It reports whether anything changed; adding an emoji twice does nothing.
+func (s *Service) React(userID, messageID uint, emoji string, add bool) (bool, error) {
+	emoji = strings.TrimSpace(emoji)
+	if !validEmoji(emoji) {
+		return false, ErrInvalidEmoji
+	}
+
+	message, err := s.visibleMessage(userID, messageID)
+	if err != nil {
+		return false, err
+	}
+	if message.UnsentAt != nil {
+		return false, ErrMessageUnsent
+	}
+
+	var result *gorm.DB
+	if add {
+		result = s.db.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.MessageReaction{MessageID: message.ID, UserID: userID, Emoji: emoji})
+	} else {
+		result = s.db.Where("message_id = ? AND user_id = ? AND emoji = ?", message.ID, userID, emoji).
+			Delete(&models.MessageReaction{})
+	}
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	action := chat.ReactionAdded
+	if !add {
+		action = chat.ReactionRemoved
+	}
+	s.notifyParticipants(message, chat.NewEnvelope(chat.EventReaction, "", chat.ReactionPayload{
+		MessageID:      message.ID,
+		ConversationID: message.ConversationID,
+		UserID:         userID,
+		Emoji:          emoji,
+		Action:         action,
+	}))
+
+	fmt.Printf("User %d %s reaction %s on message %d\n", userID, action, emoji, message.ID)
+	return true, nil
+}
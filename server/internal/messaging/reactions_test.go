@@ -0,0 +1,97 @@
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	"flux/internal/chat"
+	"flux/internal/models"
+)
+
+func TestLateJoinerCannotReactToOrQuoteEarlierMessages(t *testing.T) {
+	s := newTestService(t)
+	alice, bob, carol := newUser(t, s, "alice"), newUser(t, s, "bob"), newUser(t, s, "carol")
+	group := newGroup(t, s, alice, bob)
+
+	earlier := send(t, s, SendRequest{SenderID: alice.ID, ConversationID: group.ID, Content: "before carol"})
+	join(t, s, group, carol, earlier.CreatedAt.Add(time.Second))
+
+	if _, err := s.React(carol.ID, earlier.ID, "👍", true); err != ErrMessageNotFound {
+		t.Fatalf("reacting to an earlier message: err = %v, want %v", err, ErrMessageNotFound)
+	}
+	if _, _, err := s.Send(SendRequest{SenderID: carol.ID, ConversationID: group.ID, Content: "what?", ReplyToID: earlier.ID}); err != ErrInvalidReply {
+		t.Fatalf("quoting an earlier message: err = %v, want %v", err, ErrInvalidReply)
+	}
+
+	// Bob was already a member, so both work for them
+	if _, err := s.React(bob.ID, earlier.ID, "👍", true); err != nil {
+		t.Fatalf("bob's reaction: %v", err)
+	}
+	send(t, s, SendRequest{SenderID: bob.ID, ConversationID: group.ID, Content: "agreed", ReplyToID: earlier.ID})
+}
+
+func TestLateJoinerDoesNotSeeQuotesOfEarlierMessages(t *testing.T) {
+	s := newTestService(t)
+	alice, bob, carol := newUser(t, s, "alice"), newUser(t, s, "bob"), newUser(t, s, "carol")
+	group := newGroup(t, s, alice, bob)
+
+	earlier := send(t, s, SendRequest{SenderID: alice.ID, ConversationID: group.ID, Content: "before carol"})
+	joinedAt := earlier.CreatedAt.Add(time.Second)
+	join(t, s, group, carol, joinedAt)
+
+	// Bob can still answer the earlier message, and carol can see the answer
+	reply := send(t, s, SendRequest{SenderID: bob.ID, ConversationID: group.ID, Content: "agreed", ReplyToID: earlier.ID})
+
+	quoteOf := func(user models.User) map[string]interface{} {
+		t.Helper()
+		for _, payload := range eventsOfType(t, s, user.ID, chat.EventMessageNew) {
+			if payload["ID"] == float64(reply.ID) {
+				quote, _ := payload["reply_to"].(map[string]interface{})
+				return quote
+			}
+		}
+		t.Fatalf("user %d wasn't sent the reply", user.ID)
+		return nil
+	}
+	for _, user := range []models.User{alice, bob} {
+		if quote := quoteOf(user); quote["content"] != "before carol" {
+			t.Fatalf("user %d got the reply quoting %v, want the earlier message", user.ID, quote)
+		}
+	}
+	quote := quoteOf(carol)
+	if quote["content"] != "" || quote["sender_id"] != float64(0) || quote["before_joined"] != true {
+		t.Fatalf("carol got the reply quoting %v, want it withheld", quote)
+	}
+
+	// Loading the conversation withholds it from carol the same way
+	messages := []models.Message{reply}
+	if err := LoadReplies(s.db, messages, joinedAt); err != nil {
+		t.Fatalf("load replies: %v", err)
+	}
+	if q := messages[0].ReplyTo; q == nil || q.Content != "" || !q.BeforeJoined {
+		t.Fatalf("carol loads the reply quoting %+v, want it withheld", q)
+	}
+}
+
+func TestValidEmojiAcceptsOneEmoji(t *testing.T) {
+	valid := []string{
+		"👍", "👍🏽", "❤️", "©", "🇺🇸", "1️⃣", "#⃣",
+		"👨‍👩‍👧‍👦", "🏳️‍🌈", "🧑🏿‍🚀",
+		"🏴\U000e0067\U000e0062\U000e0065\U000e006e\U000e0067\U000e007f",
+	}
+	for _, emoji := range valid {
+		if !validEmoji(emoji) {
+			t.Errorf("%q was rejected", emoji)
+		}
+	}
+
+	invalid := []string{
+		"", "a", "ok", "1", " ", "👍 ", "👍👍👍", "©®", "❤️❤️", "🇺", "🇺🇸🇬🇧",
+		"\u200d👍", "👍\u200d", "👍\u200d\u200d👍", "\U0001f3fd", "1️⃣1️⃣", "👍a",
+	}
+	for _, emoji := range invalid {
+		if validEmoji(emoji) {
+			t.Errorf("%q was accepted", emoji)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package messaging
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"flux/internal/chat"
+	"flux/internal/models"
+)
+
+// MarkDelivered sets delivered_at on the receiver's messages that don't have it yet
+// and tells the sender's devices
+func MarkDelivered(db *gorm.DB, senderID, receiverID uint, messageIDs []uint) {
+	if len(messageIDs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	var pending []uint
+	if err := db.Model(&models.Message{}).
+		Where("id IN ? AND sender_id = ? AND receiver_id = ? AND delivered_at IS NULL", messageIDs, senderID, receiverID).
+		Pluck("id", &pending).Error; err != nil || len(pending) == 0 {
+		return
+	}
+
+	if err := db.Model(&models.Message{}).Where("id IN ?", pending).Update("delivered_at", now).Error; err != nil {
+		fmt.Printf("Failed to mark messages delivered for user %d: %v\n", receiverID, err)
+		return
+	}
+
+	chat.DefaultHub.Publish(senderID, nil, chat.NewEnvelope(chat.EventDelivered, "", chat.DeliveredPayload{
+		ReceiverID:  receiverID,
+		MessageIDs:  pending,
+		DeliveredAt: now,
+	}))
+}
+
+// MarkReplayedDelivered marks the direct messages to receiverID among events that were
+// replayed to one of the receiver's devices as delivered
+func MarkReplayedDelivered(db *gorm.DB, receiverID uint, events []chat.Envelope) {
+	bySender := make(map[uint][]uint)
+	for _, env := range events {
+		if env.Type != chat.EventMessageNew {
+			continue
+		}
+		var message models.Message
+		if err := json.Unmarshal(env.Payload, &message); err != nil || message.ReceiverID != receiverID {
+			continue
+		}
+		bySender[message.SenderID] = append(bySender[message.SenderID], message.ID)
+	}
+
+	for senderID, messageIDs := range bySender {
+		MarkDelivered(db, senderID, receiverID, messageIDs)
+	}
+}
+
+// MarkRead marks every message from senderID to readerID up to upToID as read and
+// pushes a read receipt to the sender's devices and the reader's other devices.
+// It returns how many messages changed.
+func MarkRead(db *gorm.DB, readerID, senderID, upToID uint, origin *chat.Client) (int64, error) {
+	now := time.Now()
+	scope := db.Model(&models.Message{}).
+		Where("sender_id = ? AND receiver_id = ? AND id <= ? AND read_at IS NULL", senderID, readerID, upToID)
+
+	// Reading a message implies it was delivered
+	result := scope.Updates(map[string]interface{}{
+		"read_at":      now,
+		"delivered_at": gorm.Expr("COALESCE(delivered_at, ?)", now),
+	})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, nil
+	}
+
+	// Bring the reader's unread count for the conversation back in line
+	var conversation models.Conversation
+	if err := db.Where("direct_key = ?", models.DirectKey(readerID, senderID)).First(&conversation).Error; err == nil {
+		if err := RefreshConversation(db, conversation.ID); err != nil {
+			fmt.Printf("Failed to update unread count of conversation %d: %v\n", conversation.ID, err)
+		}
+	}
+
+	receipt := chat.NewEnvelope(chat.EventRead, "", chat.ReadPayload{
+		UserID:   senderID,
+		UpToID:   upToID,
+		ReaderID: readerID,
+		ReadAt:   &now,
+	})
+	chat.DefaultHub.Publish(senderID, nil, receipt)
+	chat.DefaultHub.Publish(readerID, origin, receipt)
+
+	fmt.Printf("User %d read %d message(s) from user %d\n", readerID, result.RowsAffected, senderID)
+	return result.RowsAffected, nil
+}
+
+// MarkGroupRead moves a member's read position in a group forward to upToID and
+// pushes a read receipt to the other members and the reader's other devices
+func MarkGroupRead(db *gorm.DB, member models.ConversationMember, upToID uint, origin *chat.Client) error {
+	if upToID <= member.LastReadMessageID {
+		return nil
+	}
+
+	if err := db.Model(&member).Update("last_read_message_id", upToID).Error; err != nil {
+		return err
+	}
+	if err := RefreshConversation(db, member.ConversationID); err != nil {
+		return err
+	}
+
+	var memberIDs []uint
+	db.Model(&models.ConversationMember{}).Where("conversation_id = ?", member.ConversationID).Pluck("user_id", &memberIDs)
+
+	now := time.Now()
+	receipt := chat.NewEnvelope(chat.EventRead, "", chat.ReadPayload{
+		ConversationID: member.ConversationID,
+		UpToID:         upToID,
+		ReaderID:       member.UserID,
+		ReadAt:         &now,
+	})
+	others := make([]uint, 0, len(memberIDs))
+	for _, id := range memberIDs {
+		if id != member.UserID {
+			others = append(others, id)
+		}
+	}
+	chat.DefaultHub.PublishMany(others, receipt)
+	chat.DefaultHub.Publish(member.UserID, origin, receipt)
+	return nil
+}
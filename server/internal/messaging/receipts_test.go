@@ -0,0 +1,34 @@
+package messaging
+
+import (
+	"testing"
+
+	"flux/internal/models"
+)
+
+func TestReplayedMessagesAreDelivered(t *testing.T) {
+	s := newTestService(t)
+	alice, bob := newUser(t, s, "alice"), newUser(t, s, "bob")
+	group := newGroup(t, s, alice, bob)
+
+	// Bob is offline, so nothing reaches him live
+	toBob := send(t, s, SendRequest{SenderID: alice.ID, ReceiverID: bob.ID, Content: "are you there?"})
+	fromBob := send(t, s, SendRequest{SenderID: bob.ID, ReceiverID: alice.ID, Content: "sent from another device"})
+	send(t, s, SendRequest{SenderID: alice.ID, ConversationID: group.ID, Content: "hello everyone"})
+
+	replayed, err := NewEventLog(s.db).Since(bob.ID, 0, 100)
+	if err != nil {
+		t.Fatalf("load events: %v", err)
+	}
+	MarkReplayedDelivered(s.db, bob.ID, replayed)
+
+	var delivered, own models.Message
+	s.db.First(&delivered, toBob.ID)
+	s.db.First(&own, fromBob.ID)
+	if delivered.DeliveredAt == nil {
+		t.Fatal("message replayed to its receiver wasn't marked delivered")
+	}
+	if own.DeliveredAt != nil {
+		t.Fatal("bob's own message was marked delivered by replaying it to bob")
+	}
+}
@@ -0,0 +1,186 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"flux/internal/chat"
+	"flux/internal/models"
+)
+
+// MaxClientMsgIDLength caps the dedupe key a client can send with a message
+const MaxClientMsgIDLength = 64
+
+// Errors returned by Send for messages that can't be sent
+var (
+	ErrEmptyContent       = errors.New("message content can't be empty")
+	ErrNoReceiver         = errors.New("receiver_id or conversation_id is required")
+	ErrReceiverNotFound   = errors.New("receiver not found")
+	ErrClientMsgIDTooLong = fmt.Errorf("client_msg_id can be at most %d characters", MaxClientMsgIDLength)
+	ErrDuplicateMismatch  = errors.New("client_msg_id was already used for a different message")
+)
+
+// Service stores messages and publishes them to the hub. The REST and WebSocket
+// handlers both send through it, so a message is validated and delivered the same
+// way whichever transport it came in on.
+type Service struct {
+	db         *gorm.DB
+	editWindow time.Duration
+}
+
+func NewService(db *gorm.DB) *Service {
+	return &Service{db: db, editWindow: editWindowFromEnv()}
+}
+
+// SendRequest is a message to send, either to ReceiverID or to a conversation the
+// sender is in. ClientMsgID is an optional key chosen by the sending device; a retry
+// with the same key returns the first message instead of storing another.
+type SendRequest struct {
+	SenderID       uint
+	ReceiverID     uint
+	ConversationID uint
+	Content        string
+	ClientMsgID    string
+	ReplyToID      uint         // message in the same conversation being answered, if any
+	Origin         *chat.Client // connection the message came in on, it is acked rather than sent the message
+	AckID          string       // envelope ID to answer on Origin with a message.ack
+}
+
+// Send validates, stores and publishes a message, returning it with the sender and
+// receiver loaded. If the ClientMsgID was already used by the sender it returns the
+// stored message with duplicate set and publishes nothing. A new message is acked to
+// the Origin connection by the hub, in order with the events it sends there.
+func (s *Service) Send(req SendRequest) (message models.Message, duplicate bool, err error) {
+	if strings.TrimSpace(req.Content) == "" {
+		return message, false, ErrEmptyContent
+	}
+	if len(req.ClientMsgID) > MaxClientMsgIDLength {
+		return message, false, ErrClientMsgIDTooLong
+	}
+
+	message = models.Message{
+		SenderID:       req.SenderID,
+		ReceiverID:     req.ReceiverID,
+		ConversationID: req.ConversationID,
+		Content:        req.Content,
+		ClientMsgID:    req.ClientMsgID,
+	}
+	if req.ReplyToID != 0 {
+		message.ReplyToID = &req.ReplyToID
+	}
+
+	// A conversation_id works for groups and direct conversations alike
+	var recipients []uint
+	if req.ConversationID != 0 {
+		memberIDs, err := addressMessage(s.db, &message)
+		if err != nil {
+			return message, false, err
+		}
+		if message.ReceiverID == 0 {
+			recipients = memberIDs
+		}
+	} else {
+		if req.ReceiverID == 0 {
+			return message, false, ErrNoReceiver
+		}
+		var receivers int64
+		if err := s.db.Model(&models.User{}).Where("id = ?", req.ReceiverID).Count(&receivers).Error; err != nil {
+			return message, false, err
+		}
+		if receivers == 0 {
+			return message, false, ErrReceiverNotFound
+		}
+	}
+
+	if existing, found, err := s.findDuplicate(message); found || err != nil {
+		return existing, found, err
+	}
+
+	if message.ReplyToID != nil {
+		if err := s.checkReply(message); err != nil {
+			return message, false, err
+		}
+	}
+
+	if err := saveMessage(s.db, &message); err != nil {
+		// A retry racing the first attempt loses on the unique index
+		if existing, found, dupErr := s.findDuplicate(message); found || dupErr != nil {
+			return existing, found, dupErr
+		}
+		return message, false, err
+	}
+
+	if err := s.db.Preload("Sender").Preload("Receiver").First(&message, message.ID).Error; err != nil {
+		return message, false, err
+	}
+	if err := s.loadReply(&message); err != nil {
+		return message, false, err
+	}
+
+	s.publish(message, req.Origin, req.AckID, recipients)
+	return message, false, nil
+}
+
+// findDuplicate looks for a message the sender already stored with the same
+// ClientMsgID. Reusing a key for different content is an error.
+func (s *Service) findDuplicate(message models.Message) (models.Message, bool, error) {
+	var existing models.Message
+	if message.ClientMsgID == "" {
+		return existing, false, nil
+	}
+
+	err := s.db.Preload("Sender").Preload("Receiver").
+		Where("sender_id = ? AND client_msg_id = ?", message.SenderID, message.ClientMsgID).
+		First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return existing, false, nil
+	}
+	if err != nil {
+		return existing, false, err
+	}
+
+	if existing.Content != message.Content {
+		return existing, false, ErrDuplicateMismatch
+	}
+	if err := s.loadReply(&existing); err != nil {
+		return existing, false, err
+	}
+	fmt.Printf("Message %d from user %d was sent again with client_msg_id %q\n", existing.ID, existing.SenderID, existing.ClientMsgID)
+	return existing, true, nil
+}
+
+// publish hands a stored message to the hub for delivery to everyone's devices
+func (s *Service) publish(message models.Message, origin *chat.Client, ackID string, recipients []uint) {
+	delivery := chat.Delivery{Message: message, Origin: origin, AckID: ackID, Recipients: recipients}
+	if len(recipients) > 0 && message.ReplyTo != nil {
+		// Members who joined after the quoted message was sent get the reply without it
+		err := s.db.Model(&models.ConversationMember{}).
+			Where("conversation_id = ? AND joined_at > (?)", message.ConversationID,
+				s.db.Model(&models.Message{}).Unscoped().Select("created_at").Where("id = ?", message.ReplyTo.ID)).
+			Pluck("user_id", &delivery.QuoteWithheldFrom).Error
+		if err != nil {
+			fmt.Printf("Failed to find who joined group %d after message %d: %v\n", message.ConversationID, message.ReplyTo.ID, err)
+		}
+	}
+	if message.ReceiverID != 0 {
+		delivery.OnDelivered = func() {
+			MarkDelivered(s.db, message.SenderID, message.ReceiverID, []uint{message.ID})
+		}
+	}
+	chat.Broadcast <- delivery
+}
+
+// loadReply fills in the quote of a single message as its sender sees it; checkReply
+// made sure they could see the message they answered
+func (s *Service) loadReply(message *models.Message) error {
+	messages := []models.Message{*message}
+	if err := LoadReplies(s.db, messages, time.Time{}); err != nil {
+		return err
+	}
+	message.ReplyTo = messages[0].ReplyTo
+	return nil
+}
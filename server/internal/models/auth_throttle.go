@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuthThrottle counts recent failed attempts against a key such as a username or IP
+type AuthThrottle struct {
+	gorm.Model
+	Key           string     `json:"key" gorm:"uniqueIndex;not null"`
+	Failures      int        `json:"failures" gorm:"default:0"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+}
+
+// LockoutEvent is an audit record written whenever a key gets locked out
+type LockoutEvent struct {
+	gorm.Model
+	Key         string    `json:"key" gorm:"not null;index"`
+	Username    string    `json:"username" gorm:"index"`
+	IP          string    `json:"ip" gorm:"index"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+}
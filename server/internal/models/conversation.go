@@ -0,0 +1,84 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Conversation kinds
+const (
+	ConversationDirect = "direct"
+	ConversationGroup  = "group"
+)
+
+// Member roles in a group. The owner can do anything, admins manage members, and
+// members can only talk and leave.
+const (
+	MemberRoleOwner  = "owner"
+	MemberRoleAdmin  = "admin"
+	MemberRoleMember = "member"
+)
+
+// Conversation is a message thread. A direct conversation between two users has a
+// DirectKey built from their IDs, so each pair only ever has one. Groups have a
+// name and any number of members.
+type Conversation struct {
+	gorm.Model
+	Kind          string               `json:"kind" gorm:"not null;default:direct;index"`
+	Name          string               `json:"name,omitempty" gorm:"size:100"`
+	CreatedByID   uint                 `json:"created_by_id"`
+	DirectKey     *string              `json:"-" gorm:"uniqueIndex"`
+	LastMessageID *uint                `json:"last_message_id"`
+	LastMessageAt *time.Time           `json:"last_message_at" gorm:"index"`
+	Members       []ConversationMember `json:"members,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// ConversationMember is one user's place in a conversation, with their own unread
+// count and inbox settings. In a group, members only see messages sent after JoinedAt.
+type ConversationMember struct {
+	gorm.Model
+	ConversationID    uint      `json:"conversation_id" gorm:"not null;uniqueIndex:idx_conversation_member"`
+	UserID            uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_conversation_member;index"`
+	Role              string    `json:"role" gorm:"not null;default:member"`
+	JoinedAt          time.Time `json:"joined_at"`
+	LastReadMessageID uint      `json:"last_read_message_id" gorm:"default:0"` // groups only, direct messages track ReadAt
+	UnreadCount       int       `json:"unread_count" gorm:"not null;default:0"`
+	Muted             bool      `json:"muted" gorm:"default:false"`
+	Archived          bool      `json:"archived" gorm:"default:false"`
+	Pinned            bool      `json:"pinned" gorm:"default:false"`
+	User              User      `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// IsGroup reports whether the conversation is a group rather than a direct conversation
+func (c Conversation) IsGroup() bool {
+	return c.Kind == ConversationGroup
+}
+
+// CanManage reports whether the member can add and remove members and rename the group
+func (m ConversationMember) CanManage() bool {
+	return m.Role == MemberRoleOwner || m.Role == MemberRoleAdmin
+}
+
+// DirectKey identifies the direct conversation between two users, whichever of
+// them is asking
+func DirectKey(a, b uint) string {
+	if a > b {
+		a, b = b, a
+	}
+	return fmt.Sprintf("%d:%d", a, b)
+}
+
+// UserSummary is the public part of a user shown next to their messages
+type UserSummary struct {
+	ID          uint   `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url"`
+}
+
+// Summary returns the public part of the user
+func (u User) Summary() UserSummary {
+	return UserSummary{ID: u.ID, Username: u.Username, DisplayName: u.DisplayName, AvatarURL: u.AvatarURL}
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Statuses a DataExport moves through
+const (
+	ExportStatusPending = "pending"
+	ExportStatusRunning = "running"
+	ExportStatusReady   = "ready"
+	ExportStatusFailed  = "failed"
+	ExportStatusExpired = "expired"
+)
+
+// DataExport is a user's request for a copy of their data. The zip is built in
+// the background and deleted from disk once it expires.
+type DataExport struct {
+	gorm.Model
+	UserID      uint       `json:"user_id" gorm:"not null;index"`
+	Status      string     `json:"status" gorm:"not null;default:pending;index"`
+	Error       string     `json:"error,omitempty"`
+	FilePath    string     `json:"-"`
+	SizeBytes   int64      `json:"size_bytes"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	User        User       `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
@@ -1,14 +1,75 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
 type Message struct {
-	gorm.Model
-	SenderID   uint   `json:"sender_id" gorm:"not null"`
-	ReceiverID uint   `json:"receiver_id" gorm:"not null"`
+	// gorm.Model spelled out so CreatedAt can be part of the conversation index
+	ID         uint           `gorm:"primarykey"`
+	CreatedAt  time.Time      `gorm:"index:idx_messages_pair,priority:3"`
+	UpdatedAt  time.Time
+	DeletedAt  gorm.DeletedAt `gorm:"index"`
+	SenderID   uint   `json:"sender_id" gorm:"not null;index:idx_messages_pair,priority:1;uniqueIndex:idx_messages_client_msg,priority:1"`
+	ReceiverID uint   `json:"receiver_id" gorm:"not null;index:idx_messages_pair,priority:2"` // 0 for group messages
+	ConversationID uint `json:"conversation_id" gorm:"index"`
 	Content    string `json:"content" gorm:"type:text;not null"`
+	ClientMsgID string `json:"client_msg_id,omitempty" gorm:"size:64;not null;default:'';uniqueIndex:idx_messages_client_msg,priority:2,where:client_msg_id <> ''"` // set by the sending device to match up its own copy and drop retries
+	DeliveredAt *time.Time `json:"delivered_at"` // first reached one of the receiver's devices
+	ReadAt      *time.Time `json:"read_at"`
+	EditedAt    *time.Time `json:"edited_at"` // last time the sender changed the content
+	UnsentAt    *time.Time `json:"unsent_at"` // the sender deleted it for everyone; Content is cleared
+	ReplyToID   *uint      `json:"reply_to_id" gorm:"index"` // message in the same conversation this answers
+	ReplyTo     *MessageQuote `json:"reply_to,omitempty" gorm:"-"` // snippet of that message, filled in when sent or loaded
 	Sender     User   `json:"sender" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 	Receiver   User   `json:"receiver" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
-}
\ No newline at end of file
+}
+
+// MessageEdit keeps an earlier version of an edited message
+type MessageEdit struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	MessageID uint      `json:"message_id" gorm:"not null;index"`
+	Content   string    `json:"content" gorm:"type:text;not null"`
+	CreatedAt time.Time `json:"replaced_at"` // when this version was replaced by an edit
+}
+
+// MessageHide hides a message from one user, who deleted it just for themselves
+type MessageHide struct {
+	MessageID uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"primaryKey;index"`
+	CreatedAt time.Time
+}
+
+// MessageQuote is the snippet of the message a reply answers, shown above the reply
+type MessageQuote struct {
+	ID           uint   `json:"id"`
+	SenderID     uint   `json:"sender_id"`
+	Content      string `json:"content"`
+	Unsent       bool   `json:"unsent"`
+	BeforeJoined bool   `json:"before_joined,omitempty"` // the quoted message was sent before the viewer joined the group
+}
+
+// Withheld returns the quote as shown to a group member who joined after the quoted
+// message was sent: it says which message is answered, but not who sent it or what it said
+func (q MessageQuote) Withheld() *MessageQuote {
+	return &MessageQuote{ID: q.ID, Unsent: q.Unsent, BeforeJoined: true}
+}
+
+// MessageReaction is one user's emoji on a message. A user can add several
+// different emoji to a message, but each only once.
+type MessageReaction struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	MessageID uint      `json:"message_id" gorm:"not null;uniqueIndex:idx_message_reaction,priority:1"`
+	UserID    uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_message_reaction,priority:2"`
+	Emoji     string    `json:"emoji" gorm:"size:32;not null;uniqueIndex:idx_message_reaction,priority:3"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReactionSummary counts the users who reacted to a message with one emoji
+type ReactionSummary struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	UserIDs []uint `json:"user_ids"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session is a single logged-in device. Access tokens carry the session's
+// JTI so a revoked session is rejected on the very next request.
+type Session struct {
+	gorm.Model
+	UserID     uint       `json:"user_id" gorm:"not null;index"`
+	JTI        string     `json:"-" gorm:"uniqueIndex;not null"`
+	DeviceID   string     `json:"device_id" gorm:"not null"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	User       User       `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
@@ -1,15 +1,40 @@
 package models
 
 import (
+	"time"
+
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+// Account statuses
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+	UserStatusBanned    = "banned"
+)
+
 type User struct {
     gorm.Model
     Username        string `json:"username" gorm:"uniqueIndex;not null"`
     Email           string `json:"email" gorm:"uniqueIndex;not null"`
     PasswordHash    string `json:"-" gorm:"not null"` // "-" means don't show in JSON responses
+    DisplayName     string `json:"display_name" gorm:"size:50"`
+    Bio             string `json:"bio" gorm:"size:300"`
+    AvatarURL       string `json:"avatar_url"`
+    Website         string `json:"website"`
+    EmailVerified   bool   `json:"email_verified" gorm:"default:false"`
+    TOTPSecret      string `json:"-"`
+    TOTPEnabled     bool   `json:"totp_enabled" gorm:"default:false"`
+    TOTPLastStep    int64  `json:"-" gorm:"default:0"` // last accepted time step, so a code can't be replayed
+    Role            string `json:"role" gorm:"not null;default:user;index"`
+    Status          string `json:"status" gorm:"not null;default:active;index"`
+    StatusReason    string `json:"status_reason,omitempty"`
+    SuspendedUntil  *time.Time `json:"suspended_until,omitempty"`
+    MustResetPassword bool `json:"must_reset_password" gorm:"default:false"`
+    HideLastSeen    bool   `json:"hide_last_seen" gorm:"default:false"` // privacy: don't show others when we were last online
+    LastSeenAt      *time.Time `json:"-"` // only shared through presence, which honours HideLastSeen
+    EventSeq        uint64 `json:"-" gorm:"not null;default:0"` // sequence number of the newest UserEvent
     FollowersCount  int    `json:"followers_count" gorm:"default:0"`
     FollowingCount  int    `json:"following_count" gorm:"default:0"`
     
@@ -36,4 +61,13 @@ func (u *User) CheckPassword(password string) error {
     return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) 
 }
 
-
+// IsSuspended reports whether the account is banned or inside an active suspension
+func (u *User) IsSuspended() bool {
+    switch u.Status {
+    case UserStatusBanned:
+        return true
+    case UserStatusSuspended:
+        return u.SuspendedUntil == nil || time.Now().Before(*u.SuspendedUntil)
+    }
+    return false
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// UserEvent is a real-time event sent to a user, kept for a while so a client that
+// reconnects can replay what it missed. Seq counts up from 1 for each user.
+type UserEvent struct {
+	ID        uint      `json:"-" gorm:"primarykey"`
+	UserID    uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_user_events_seq,priority:1"`
+	Seq       uint64    `json:"seq" gorm:"not null;uniqueIndex:idx_user_events_seq,priority:2"`
+	Type      string    `json:"type" gorm:"size:50;not null"`
+	Payload   string    `json:"payload" gorm:"type:text"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserIdentity links a user to an account at an external OpenID Connect provider
+type UserIdentity struct {
+	gorm.Model
+	UserID   uint   `json:"user_id" gorm:"not null;index"`
+	Provider string `json:"provider" gorm:"not null;uniqueIndex:idx_identity_provider_subject"`
+	Subject  string `json:"-" gorm:"not null;uniqueIndex:idx_identity_provider_subject"`
+	Email    string `json:"email"`
+	User     User   `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// OIDCLoginState holds the PKCE verifier and nonce for an OIDC login until the
+// provider redirects back. It is deleted as soon as it is used.
+type OIDCLoginState struct {
+	gorm.Model
+	StateHash    string    `gorm:"uniqueIndex;not null"`
+	Provider     string    `gorm:"not null"`
+	Nonce        string    `gorm:"not null"`
+	CodeVerifier string    `gorm:"not null"`
+	ExpiresAt    time.Time `gorm:"not null"`
+}
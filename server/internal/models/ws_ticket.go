@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WSTicket is a short-lived, single-use ticket for opening a WebSocket. It is
+// bound to the session and browser origin it was issued to, and only its hash
+// is stored.
+type WSTicket struct {
+	gorm.Model
+	UserID     uint       `json:"user_id" gorm:"not null;index"`
+	SessionJTI string     `json:"-" gorm:"not null"`
+	TicketHash string     `json:"-" gorm:"uniqueIndex;not null"`
+	Origin     string     `json:"origin"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null;index"`
+	UsedAt     *time.Time `json:"used_at"`
+	User       User       `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
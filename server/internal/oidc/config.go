@@ -0,0 +1,49 @@
+package oidc
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadProvidersFromEnv builds the providers listed in OIDC_PROVIDERS (comma separated).
+// Each provider NAME is configured with:
+//
+//	OIDC_<NAME>_ISSUER         issuer URL, used for discovery
+//	OIDC_<NAME>_CLIENT_ID      client ID registered with the provider
+//	OIDC_<NAME>_CLIENT_SECRET  client secret (optional for public clients)
+//	OIDC_<NAME>_REDIRECT_URL   redirect URI registered with the provider
+//	OIDC_<NAME>_SCOPES         extra scopes, space separated (optional)
+func LoadProvidersFromEnv() (map[string]*Provider, error) {
+	providers := make(map[string]*Provider)
+
+	names := os.Getenv("OIDC_PROVIDERS")
+	if names == "" {
+		return providers, nil
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		config := Config{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+
+		if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+			return nil, fmt.Errorf("OIDC provider %q needs %sISSUER, %sCLIENT_ID and %sREDIRECT_URL", name, prefix, prefix, prefix)
+		}
+
+		providers[name] = NewProvider(config)
+	}
+
+	return providers, nil
+}
@@ -0,0 +1,31 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// GenerateVerifier returns a random PKCE code verifier (RFC 7636)
+func GenerateVerifier() (string, error) {
+	return randomString(32)
+}
+
+// GenerateNonce returns a random value to bind an ID token to a login attempt
+func GenerateNonce() (string, error) {
+	return randomString(16)
+}
+
+// CodeChallenge derives the S256 code challenge for a verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,124 @@
+package oidc
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"flux/internal/oidc/oidctest"
+)
+
+var alice = oidctest.Identity{Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true, Username: "alice"}
+
+// newTestProvider starts a mock provider and returns it with a Provider configured for it
+func newTestProvider(t *testing.T) (*oidctest.Provider, *Provider) {
+	t.Helper()
+
+	mock, err := oidctest.New("")
+	if err != nil {
+		t.Fatalf("start mock provider: %v", err)
+	}
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+	mock.Issuer = server.URL
+
+	return mock, NewProvider(Config{
+		Name:        "mock",
+		Issuer:      server.URL,
+		ClientID:    "flux",
+		RedirectURL: "http://localhost:5173/oauth/callback",
+	})
+}
+
+// authorize starts a login with the nonce and verifier and returns the code the
+// provider hands back for the identity
+func authorize(t *testing.T, mock *oidctest.Provider, p *Provider, nonce, verifier string) string {
+	t.Helper()
+
+	authURL, err := p.AuthCodeURL(context.Background(), "state", nonce, verifier)
+	if err != nil {
+		t.Fatalf("build authorization URL: %v", err)
+	}
+	code, _, err := mock.Authorize(authURL, alice)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	return code
+}
+
+func TestExchangeReturnsVerifiedClaims(t *testing.T) {
+	mock, p := newTestProvider(t)
+
+	claims, err := p.Exchange(context.Background(), authorize(t, mock, p, "nonce", "verifier"), "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if claims.Subject != alice.Subject || claims.Email != alice.Email || !claims.EmailVerified {
+		t.Fatalf("claims = %+v, want alice's verified identity", claims)
+	}
+}
+
+func TestAuthCodeURLUsesPKCE(t *testing.T) {
+	_, p := newTestProvider(t)
+
+	authURL, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatalf("build authorization URL: %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parse authorization URL: %v", err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge") != CodeChallenge("verifier") || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorization URL %s doesn't carry the S256 challenge", authURL)
+	}
+	if strings.Contains(authURL, "verifier") {
+		t.Fatal("authorization URL leaks the code verifier")
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	mock, p := newTestProvider(t)
+
+	if _, err := p.Exchange(context.Background(), authorize(t, mock, p, "nonce", "verifier"), "another verifier", "nonce"); err == nil {
+		t.Fatal("code was redeemed without its verifier")
+	}
+}
+
+func TestExchangeRejectsNonceMismatch(t *testing.T) {
+	mock, p := newTestProvider(t)
+
+	_, err := p.Exchange(context.Background(), authorize(t, mock, p, "nonce", "verifier"), "verifier", "another nonce")
+	if err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Fatalf("err = %v, want a nonce mismatch", err)
+	}
+}
+
+func TestExchangeRejectsTamperedClaims(t *testing.T) {
+	tests := []struct {
+		name  string
+		claim string
+		value interface{}
+	}{
+		{"wrong audience", "aud", "someone-else"},
+		{"wrong issuer", "iss", "https://evil.example.com"},
+		{"expired", "exp", 1},
+		{"no subject", "sub", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock, p := newTestProvider(t)
+			mock.Tamper = func(claims jwt.MapClaims) { claims[tt.claim] = tt.value }
+
+			if _, err := p.Exchange(context.Background(), authorize(t, mock, p, "nonce", "verifier"), "verifier", "nonce"); err == nil {
+				t.Fatalf("ID token with %s = %v was accepted", tt.claim, tt.value)
+			}
+		})
+	}
+}